@@ -1,3 +1,8 @@
 module github.com/tfes-dev/tfes
 
 go 1.17
+
+require (
+	github.com/gorilla/websocket v1.4.2
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
+)
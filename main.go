@@ -3,8 +3,10 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"github.com/tfes-dev/tfes/pkg/auth"
 	"github.com/tfes-dev/tfes/pkg/net"
 	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/store"
 	"io/ioutil"
 )
 
@@ -24,13 +26,41 @@ func main() {
 		panic(err)
 	}
 
+	err = auth.CheckMapUser(&config)
+	if err != nil {
+		panic(err)
+	}
+
 	msgsToPeers := make(chan *schemas.Message, 200)
 	msgsFromPeers := make(chan *schemas.Message, 200)
 
 	peerServer := net.NewPeerListener(&config, msgsToPeers, msgsFromPeers)
 	go peerServer.Start()
 
-	tcpPool := net.NewTcpHandlerPool(&config, msgsToPeers, msgsFromPeers)
+	messageStore, err := store.NewStore(config.Storage)
+	if err != nil {
+		panic(err)
+	}
+
+	tcpPool := net.NewTcpHandlerPool(&config, messageStore, msgsToPeers, msgsFromPeers, peerServer.Interested, peerServer.Status)
+	if config.Websocket != nil {
+		go func() {
+			err := tcpPool.StartWebsocket()
+			if err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+	if config.Http != nil {
+		go func() {
+			err := tcpPool.StartHttp()
+			if err != nil {
+				panic(err)
+			}
+		}()
+	}
+
 	err = tcpPool.Start()
 	if err != nil {
 		panic(err)
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	AuthenticationError = errors.New("authentication failed")
+	NoUsersToMapError   = errors.New("map_user requires users in the authorization section to map certificates to")
+)
+
+// Authenticator verifies the credentials sent by clients in their CONNECT message
+type Authenticator struct {
+	config *schemas.Authorization
+	users  map[string]*schemas.User
+}
+
+func NewAuthenticator(config *schemas.Authorization) *Authenticator {
+	users := make(map[string]*schemas.User)
+	if config != nil {
+		for _, user := range config.Users {
+			users[user.User] = user
+		}
+	}
+	return &Authenticator{
+		config: config,
+		users:  users,
+	}
+}
+
+// CheckMapUser returns an error if a listener maps certificates to users while none is configured,
+// which would reject every client presenting a certificate
+func CheckMapUser(config *schemas.Config) error {
+	listeners := make([]*schemas.Tls, 0, 3)
+	if config.Server != nil {
+		listeners = append(listeners, config.Server.Tls)
+	}
+	if config.Websocket != nil {
+		listeners = append(listeners, config.Websocket.Tls)
+	}
+	if config.Http != nil {
+		listeners = append(listeners, config.Http.Tls)
+	}
+
+	hasUsers := config.Authorization != nil && len(config.Authorization.Users) > 0
+	for _, tlsConfig := range listeners {
+		if tlsConfig != nil && tlsConfig.MapUser && !hasUsers {
+			return NoUsersToMapError
+		}
+	}
+	return nil
+}
+
+// Authenticate returns the user matching the credentials.
+// The user is nil if authorization is disabled or the client authenticated with the shared token.
+func (a *Authenticator) Authenticate(connect *schemas.Connect) (*schemas.User, error) {
+	if a.config == nil {
+		return nil, nil
+	}
+
+	if len(connect.User) > 0 {
+		user, ok := a.users[connect.User]
+		if !ok {
+			return nil, AuthenticationError
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(connect.Password)) != nil {
+			return nil, AuthenticationError
+		}
+		return user, nil
+	}
+
+	if len(a.config.Token) > 0 && subtle.ConstantTimeCompare([]byte(a.config.Token), []byte(connect.Token)) == 1 {
+		return nil, nil
+	}
+
+	return nil, AuthenticationError
+}
+
+// AuthenticateCertificate returns the user named by the certificate's subject common name or SANs
+func (a *Authenticator) AuthenticateCertificate(cert *x509.Certificate) (*schemas.User, error) {
+	identities := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+
+	for _, identity := range identities {
+		if user, ok := a.users[identity]; ok {
+			return user, nil
+		}
+	}
+	return nil, AuthenticationError
+}
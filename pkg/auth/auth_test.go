@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+func TestCheckMapUser(t *testing.T) {
+	mapUser := &schemas.Tls{Cert: "server.pem", Key: "server.key", MapUser: true}
+
+	config := &schemas.Config{Websocket: &schemas.Websocket{Tls: mapUser}}
+	if err := CheckMapUser(config); err != NoUsersToMapError {
+		t.Errorf("CheckMapUser() without users error = %v, want %v", err, NoUsersToMapError)
+	}
+
+	config.Authorization = &schemas.Authorization{Users: []*schemas.User{{User: "alice"}}}
+	if err := CheckMapUser(config); err != nil {
+		t.Errorf("CheckMapUser() with users error = %v", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+	alice := &schemas.User{User: "alice", Password: string(hash)}
+	a := NewAuthenticator(&schemas.Authorization{Token: "t0ken", Users: []*schemas.User{alice}})
+
+	tests := []struct {
+		name    string
+		connect *schemas.Connect
+		want    *schemas.User
+		wantErr error
+	}{
+		{"Correct password", &schemas.Connect{User: "alice", Password: "s3cret"}, alice, nil},
+		{"Wrong password", &schemas.Connect{User: "alice", Password: "guess"}, nil, AuthenticationError},
+		{"Unknown user", &schemas.Connect{User: "bob", Password: "s3cret"}, nil, AuthenticationError},
+		{"Token", &schemas.Connect{Token: "t0ken"}, nil, nil},
+		{"Wrong token", &schemas.Connect{Token: "guess"}, nil, AuthenticationError},
+		{"No credentials", &schemas.Connect{}, nil, AuthenticationError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := a.Authenticate(tt.connect)
+			if user != tt.want || err != tt.wantErr {
+				t.Errorf("Authenticate() = %v, %v, want %v, %v", user, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithoutAuthorization(t *testing.T) {
+	if user, err := NewAuthenticator(nil).Authenticate(&schemas.Connect{}); user != nil || err != nil {
+		t.Errorf("Authenticate() = %v, %v, want every client accepted", user, err)
+	}
+}
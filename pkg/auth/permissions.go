@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tfes-dev/tfes/pkg/routing"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+)
+
+var (
+	PermissionDeniedError = errors.New("permission denied")
+)
+
+// CheckPublish returns a descriptive error if the user may not publish to the subject
+func CheckPublish(user *schemas.User, subject string) error {
+	// Replies go to inboxes minted by the server, which every user may answer
+	if routing.IsInbox(subject) {
+		return nil
+	}
+	if user == nil || user.Permissions == nil || isAllowed(user.Permissions.Publish, subject) {
+		return nil
+	}
+	return fmt.Errorf("%w: user %s cannot publish to %s", PermissionDeniedError, user.User, subject)
+}
+
+// CheckSubscribe returns a descriptive error if the user may not subscribe to the subject
+func CheckSubscribe(user *schemas.User, subject string) error {
+	if user == nil || user.Permissions == nil || isAllowed(user.Permissions.Subscribe, subject) {
+		return nil
+	}
+	return fmt.Errorf("%w: user %s cannot subscribe to %s", PermissionDeniedError, user.User, subject)
+}
+
+// CheckLeaf returns a descriptive error if the user may not connect leaf nodes
+func CheckLeaf(user *schemas.User) error {
+	if user == nil || user.Permissions == nil || user.Permissions.Leaf {
+		return nil
+	}
+	return fmt.Errorf("%w: user %s cannot connect leaf nodes", PermissionDeniedError, user.User)
+}
+
+func isAllowed(permission *schemas.SubjectPermission, subject string) bool {
+	if permission == nil {
+		return true
+	}
+
+	if len(permission.Allow) > 0 {
+		allowed := false
+		for _, pattern := range permission.Allow {
+			if routing.MatchSubject(subject, pattern) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	for _, pattern := range permission.Deny {
+		if routing.MatchSubject(subject, pattern) {
+			return false
+		}
+	}
+	return true
+}
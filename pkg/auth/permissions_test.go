@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"testing"
+)
+
+var permissionTests = []struct {
+	name       string
+	permission *schemas.SubjectPermission
+	subject    string
+	want       bool
+}{
+	{
+		name:       "No permissions",
+		permission: nil,
+		subject:    "billing.invoice",
+		want:       true,
+	},
+	{
+		name:       "Allowed by wildcard",
+		permission: &schemas.SubjectPermission{Allow: []string{"billing.>"}},
+		subject:    "billing.invoice",
+		want:       true,
+	},
+	{
+		name:       "Not in allow list",
+		permission: &schemas.SubjectPermission{Allow: []string{"billing.>"}},
+		subject:    "orders.created",
+		want:       false,
+	},
+	{
+		name:       "Denied by wildcard",
+		permission: &schemas.SubjectPermission{Deny: []string{"secrets.>"}},
+		subject:    "secrets.db.password",
+		want:       false,
+	},
+	{
+		name:       "Deny takes precedence",
+		permission: &schemas.SubjectPermission{Allow: []string{">"}, Deny: []string{"billing.*"}},
+		subject:    "billing.invoice",
+		want:       false,
+	},
+}
+
+func TestIsAllowed(t *testing.T) {
+	for _, tt := range permissionTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAllowed(tt.permission, tt.subject); got != tt.want {
+				t.Errorf("isAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLeaf(t *testing.T) {
+	restricted := &schemas.Permissions{Publish: &schemas.SubjectPermission{Allow: []string{"sensors.>"}}}
+	leaf := &schemas.Permissions{Publish: restricted.Publish, Leaf: true}
+
+	if err := CheckLeaf(nil); err != nil {
+		t.Errorf("CheckLeaf() without a user error = %v", err)
+	}
+	if err := CheckLeaf(&schemas.User{User: "edge", Permissions: leaf}); err != nil {
+		t.Errorf("CheckLeaf() of a leaf user error = %v", err)
+	}
+	if err := CheckLeaf(&schemas.User{User: "sensor", Permissions: restricted}); !errors.Is(err, PermissionDeniedError) {
+		t.Errorf("CheckLeaf() of a restricted user error = %v, want %v", err, PermissionDeniedError)
+	}
+}
@@ -0,0 +1,196 @@
+package net
+
+import (
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/auth"
+	"github.com/tfes-dev/tfes/pkg/routing"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/store"
+	"github.com/tfes-dev/tfes/pkg/utils"
+)
+
+var (
+	StreamNotFoundError   = errors.New("stream not found")
+	ConsumerNotFoundError = errors.New("consumer not found")
+	NotBoundError         = errors.New("client is not bound to the consumer")
+)
+
+// bindConsumer makes the client one of those receiving messages from a durable consumer
+func (pool *TcpHandlerPool) bindConsumer(subscribe *schemas.Subscribe, cc *schemas.ClientConnection) *schemas.Message {
+	// Messages the filter drops would have to be acknowledged on behalf of the client, so filters are left to the consumer's subjects
+	if len(subscribe.Filter) > 0 {
+		return utils.ReturnErrorAck(FilterNotSupportedError)
+	}
+
+	consumer, err := pool.findConsumer(subscribe.Stream, subscribe.Durable)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	for _, subject := range consumer.Subjects() {
+		if err := auth.CheckSubscribe(cc.User, subject); err != nil {
+			return utils.ReturnErrorAck(err)
+		}
+	}
+
+	pool.bindingLock.Lock()
+	pool.bindings[cc] = append(pool.bindings[cc], consumer)
+	pool.bindingLock.Unlock()
+
+	consumer.Bind(cc, func(stored *schemas.StoredMessage, deliveries int) error {
+		// The permissions may cover only part of the consumer's subjects, or have changed since the client bound
+		if auth.CheckSubscribe(cc.User, stored.Subject) != nil {
+			return store.DeliveryRefusedError
+		}
+		return writeToClient(cc, &schemas.Message{
+			Kind:   schemas.KindBounty,
+			Header: stored.Header,
+			Bounty: stored.ToBounty(subscribe.Stream, subscribe.Durable, deliveries),
+		})
+	})
+	return utils.ReturnSuccessAck()
+}
+
+func (pool *TcpHandlerPool) unbindConsumer(unsubscribe *schemas.Unsubscribe, cc *schemas.ClientConnection) *schemas.Message {
+	consumer, err := pool.findConsumer(unsubscribe.Stream, unsubscribe.Durable)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	pool.bindingLock.Lock()
+	bound := pool.bindings[cc]
+	for i, c := range bound {
+		if c == consumer {
+			pool.bindings[cc] = append(bound[:i], bound[i+1:]...)
+			break
+		}
+	}
+	pool.bindingLock.Unlock()
+
+	consumer.Unbind(cc)
+	return utils.ReturnSuccessAck()
+}
+
+// unbindConsumers unbinds a disconnected client from every consumer, and stops its replays
+func (pool *TcpHandlerPool) unbindConsumers(cc *schemas.ClientConnection) {
+	pool.bindingLock.Lock()
+	bound := pool.bindings[cc]
+	replays := pool.replays[cc]
+	delete(pool.bindings, cc)
+	delete(pool.replays, cc)
+	pool.bindingLock.Unlock()
+
+	for _, consumer := range bound {
+		consumer.Unbind(cc)
+	}
+	for _, replay := range replays {
+		replay.Stop()
+	}
+}
+
+// startReplay sends the client the stored messages of the subject, followed by the new ones as they are stored
+func (pool *TcpHandlerPool) startReplay(subscribe *schemas.Subscribe, cc *schemas.ClientConnection) *schemas.Message {
+	stream := pool.store.Stream(subscribe.Replay.Stream)
+	if stream == nil {
+		return utils.ReturnErrorAck(StreamNotFoundError)
+	}
+
+	// An empty subject would replay the whole stream, so wildcards must be asked for explicitly
+	if len(subscribe.Subject) == 0 {
+		return utils.ReturnErrorAck(routing.InvalidSubjectError)
+	}
+	if err := auth.CheckSubscribe(cc.User, subscribe.Subject); err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	filter, err := compileFilter(subscribe)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	streamName := subscribe.Replay.Stream
+	replay, err := stream.Replay(subscribe.Subject, subscribe.Replay, func(stored *schemas.StoredMessage) error {
+		// Wildcard subscriptions may still cover subjects the client is not allowed to receive
+		if auth.CheckSubscribe(cc.User, stored.Subject) != nil {
+			return nil
+		}
+		if filter != nil && !filter.Match(stored.Headers, stored.Body) {
+			return nil
+		}
+		return writeToClient(cc, &schemas.Message{
+			Kind:   schemas.KindBounty,
+			Header: stored.Header,
+			Bounty: stored.ToBounty(streamName, "", 0),
+		})
+	})
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	pool.bindingLock.Lock()
+	pool.replays[cc] = append(pool.replays[cc], replay)
+	pool.bindingLock.Unlock()
+	return utils.ReturnSuccessAck()
+}
+
+func (pool *TcpHandlerPool) stopReplay(unsubscribe *schemas.Unsubscribe, cc *schemas.ClientConnection) *schemas.Message {
+	stream := pool.store.Stream(unsubscribe.Stream)
+	if stream == nil {
+		return utils.ReturnErrorAck(StreamNotFoundError)
+	}
+
+	pool.bindingLock.Lock()
+	replays := pool.replays[cc]
+	kept := replays[:0]
+	for _, replay := range replays {
+		if replay.Stream() == stream && replay.Filter() == unsubscribe.Subject {
+			replay.Stop()
+			continue
+		}
+		kept = append(kept, replay)
+	}
+	pool.replays[cc] = kept
+	pool.bindingLock.Unlock()
+	return utils.ReturnSuccessAck()
+}
+
+func (pool *TcpHandlerPool) handleMessageAck(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	ack := msg.MessageAck
+	consumer, err := pool.findConsumer(ack.Stream, ack.Durable)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	if !pool.isBound(cc, consumer) {
+		return utils.ReturnErrorAck(NotBoundError)
+	}
+
+	if err := consumer.Ack(ack.Sequence); err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	return utils.ReturnSuccessAck()
+}
+
+func (pool *TcpHandlerPool) isBound(cc *schemas.ClientConnection, consumer *store.Consumer) bool {
+	pool.bindingLock.Lock()
+	defer pool.bindingLock.Unlock()
+
+	for _, c := range pool.bindings[cc] {
+		if c == consumer {
+			return true
+		}
+	}
+	return false
+}
+
+func (pool *TcpHandlerPool) findConsumer(streamName string, durable string) (*store.Consumer, error) {
+	stream := pool.store.Stream(streamName)
+	if stream == nil {
+		return nil, StreamNotFoundError
+	}
+
+	consumer := stream.Consumer(durable)
+	if consumer == nil {
+		return nil, ConsumerNotFoundError
+	}
+	return consumer, nil
+}
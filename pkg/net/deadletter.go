@@ -0,0 +1,46 @@
+package net
+
+import (
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/utils"
+	"log"
+)
+
+// deadLetterUnhandled routes a guaranteed publish nothing was interested in to the server's dead-letter subject.
+// The publisher is told about it if there is no such subject, rather than the message being silently dropped.
+func (pool *TcpHandlerPool) deadLetterUnhandled(msg *schemas.Message) *schemas.Message {
+	subject := pool.config.Server.DeadLetterSubject
+	if len(subject) == 0 {
+		return utils.ReturnErrorAck(NoSubscribersError)
+	}
+
+	pool.publishDeadLetter(&schemas.Message{
+		Kind: schemas.KindPublish,
+		Header: &schemas.Header{DeadLetter: &schemas.DeadLetter{
+			Reason:  schemas.DeadLetterNoSubscribers,
+			Subject: msg.Publish.Subject,
+		}},
+		Publish: &schemas.Publish{
+			Subject: subject,
+			ReplyTo: msg.Publish.ReplyTo,
+			Body:    msg.Publish.Body,
+			Headers: msg.Publish.Headers,
+		},
+	})
+	return utils.ReturnSuccessAck()
+}
+
+// publishDeadLetter publishes a message routed to a dead-letter subject, which is never guaranteed itself
+func (pool *TcpHandlerPool) publishDeadLetter(msg *schemas.Message) {
+	log.Printf("Routing message on %s to dead-letter subject %s: %s\n", msg.Header.DeadLetter.Subject, msg.Publish.Subject, msg.Header.DeadLetter.Reason)
+
+	msg.Publish.Guaranteed = false
+
+	// The original headers are kept, except for the condition for storing the original message
+	msg.Publish.Headers = msg.Publish.Headers.Clone()
+	delete(msg.Publish.Headers, schemas.HeaderExpectedLastSequence)
+	response := pool.publish(msg)
+	if !response.Ack.Ok {
+		log.Println("Failed to publish dead letter:", response.Ack.Description)
+	}
+}
@@ -0,0 +1,67 @@
+package net
+
+import (
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/routing"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+)
+
+var (
+	FilterNotSupportedError = errors.New("filters are not supported on durable consumers")
+)
+
+// compileFilter returns nil when the subscription has no filter
+func compileFilter(subscribe *schemas.Subscribe) (*routing.Filter, error) {
+	if len(subscribe.Filter) == 0 {
+		return nil, nil
+	}
+	return routing.CompileFilter(subscribe.Filter)
+}
+
+// setFilter records the filter of the client's subscription to the subject, nil for none.
+// A later subscription to the same subject replaces the filter.
+func (pool *TcpHandlerPool) setFilter(cc *schemas.ClientConnection, subject string, filter *routing.Filter) {
+	pool.filterLock.Lock()
+	defer pool.filterLock.Unlock()
+
+	if pool.filters[cc] == nil {
+		pool.filters[cc] = make(map[string]*routing.Filter)
+	}
+	pool.filters[cc][subject] = filter
+}
+
+func (pool *TcpHandlerPool) removeFilter(cc *schemas.ClientConnection, subject string) {
+	pool.filterLock.Lock()
+	defer pool.filterLock.Unlock()
+
+	delete(pool.filters[cc], subject)
+	if len(pool.filters[cc]) == 0 {
+		delete(pool.filters, cc)
+	}
+}
+
+func (pool *TcpHandlerPool) removeFilters(cc *schemas.ClientConnection) {
+	pool.filterLock.Lock()
+	delete(pool.filters, cc)
+	pool.filterLock.Unlock()
+}
+
+// passesFilters tells whether any subscription of the client to the subject of the publish lets it through
+func (pool *TcpHandlerPool) passesFilters(cc *schemas.ClientConnection, publish *schemas.Publish) bool {
+	pool.filterLock.RLock()
+	defer pool.filterLock.RUnlock()
+
+	filters, ok := pool.filters[cc]
+	if !ok {
+		return true
+	}
+	for subject, filter := range filters {
+		if !routing.MatchSubject(publish.Subject, subject) {
+			continue
+		}
+		if filter == nil || filter.Match(publish.Headers, publish.Body) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package net
+
+import (
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/utils"
+	"log"
+)
+
+const (
+	// MaxDiscoveredRouteFailures is the number of failed dials after which a discovered route is given up.
+	// The member is dialed again if it is gossiped to have joined once more.
+	MaxDiscoveredRouteFailures = 10
+)
+
+// gossipJoined runs once the name of a newly connected peer is known.
+// The peer is sent the other members this server is connected to, and if it is new to this server,
+// the other members are told it joined.
+func (p *PeerServer) gossipJoined(pc *schemas.PeerConnection) {
+	if len(pc.PeerName) == 0 {
+		return
+	}
+	joined := &schemas.Member{Name: pc.PeerName, AdvertiseAddr: pc.PeerUri}
+
+	p.peerLock.RLock()
+	first := true
+	members := make([]*schemas.Member, 0)
+	others := make([]*schemas.PeerConnection, 0)
+	seen := map[string]bool{pc.PeerName: true}
+	for _, peer := range p.Peers {
+		if peer.PeerName == pc.PeerName && peer != pc {
+			first = false
+		}
+		if len(peer.PeerName) == 0 || seen[peer.PeerName] {
+			continue
+		}
+		seen[peer.PeerName] = true
+		members = append(members, &schemas.Member{Name: peer.PeerName, AdvertiseAddr: peer.PeerUri})
+		others = append(others, peer)
+	}
+	p.peerLock.RUnlock()
+
+	if len(members) > 0 {
+		writeToPeer(pc, &schemas.Message{Kind: schemas.KindPeerGossip, PeerGossip: &schemas.PeerGossip{Joined: members}})
+	}
+	if !first {
+		return
+	}
+	log.Printf("Member %s joined at %s\n", joined.Name, joined.AdvertiseAddr)
+	for _, peer := range others {
+		writeToPeer(peer, &schemas.Message{Kind: schemas.KindPeerGossip, PeerGossip: &schemas.PeerGossip{Joined: []*schemas.Member{joined}}})
+	}
+}
+
+// gossipLeft tells the remaining members that this server lost its last connection to the peer
+func (p *PeerServer) gossipLeft(pc *schemas.PeerConnection) {
+	if len(pc.PeerName) == 0 {
+		return
+	}
+	left := &schemas.Member{Name: pc.PeerName, AdvertiseAddr: pc.PeerUri}
+	log.Printf("Member %s left\n", left.Name)
+
+	p.peerLock.RLock()
+	peers := append([]*schemas.PeerConnection(nil), p.Peers...)
+	p.peerLock.RUnlock()
+
+	for _, peer := range peers {
+		writeToPeer(peer, &schemas.Message{Kind: schemas.KindPeerGossip, PeerGossip: &schemas.PeerGossip{Left: []*schemas.Member{left}}})
+	}
+}
+
+func (p *PeerServer) handlePeerGossip(message *schemas.Message, connection *schemas.PeerConnection) *schemas.Message {
+	for _, member := range message.PeerGossip.Joined {
+		p.discover(member, connection)
+	}
+	for _, member := range message.PeerGossip.Left {
+		p.forget(member, connection)
+	}
+	return utils.ReturnSuccessAck()
+}
+
+// discover dials a member this server is not connected to yet.
+// Of two members, the one with the lesser name dials the other, so that they do not both dial at once.
+func (p *PeerServer) discover(member *schemas.Member, from *schemas.PeerConnection) {
+	if len(member.Name) == 0 || len(member.AdvertiseAddr) == 0 || member.Name <= p.config.Server.Name {
+		return
+	}
+
+	p.peerLock.RLock()
+	connected := p.findPeer(member.Name) != nil
+	p.peerLock.RUnlock()
+	if connected {
+		return
+	}
+
+	p.routeLock.Lock()
+	for _, status := range p.routes {
+		if status.Name == member.Name || status.Url == member.AdvertiseAddr {
+			p.routeLock.Unlock()
+			return
+		}
+	}
+	status := &schemas.RouteStatus{Name: member.Name, Url: member.AdvertiseAddr, Discovered: true}
+	p.routes = append(p.routes, status)
+	p.routeLock.Unlock()
+
+	log.Printf("Discovered member %s at %s through %s\n", member.Name, member.AdvertiseAddr, from.PeerName)
+	go p.superviseRoute(&schemas.Route{Name: member.Name, Url: member.AdvertiseAddr}, status)
+}
+
+// forget gives up the discovered route to a member which left, unless this server is still connected to it
+func (p *PeerServer) forget(member *schemas.Member, from *schemas.PeerConnection) {
+	p.peerLock.RLock()
+	connected := p.findPeer(member.Name) != nil
+	p.peerLock.RUnlock()
+	if connected {
+		return
+	}
+
+	log.Printf("Member %s left, as told by %s\n", member.Name, from.PeerName)
+	p.routeLock.Lock()
+	defer p.routeLock.Unlock()
+	for i, status := range p.routes {
+		if status.Discovered && status.Name == member.Name {
+			p.routes = append(p.routes[:i], p.routes[i+1:]...)
+			return
+		}
+	}
+}
+
+// keepRoute tells whether the supervisor of the route should go on dialing it
+func (p *PeerServer) keepRoute(status *schemas.RouteStatus) bool {
+	p.routeLock.Lock()
+	defer p.routeLock.Unlock()
+
+	for i, s := range p.routes {
+		if s != status {
+			continue
+		}
+		if status.Discovered && status.Failures >= MaxDiscoveredRouteFailures {
+			p.routes = append(p.routes[:i], p.routes[i+1:]...)
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// dropRoute stops the supervisor of the route from dialing it again
+func (p *PeerServer) dropRoute(status *schemas.RouteStatus) {
+	p.routeLock.Lock()
+	defer p.routeLock.Unlock()
+
+	for i, s := range p.routes {
+		if s == status {
+			p.routes = append(p.routes[:i], p.routes[i+1:]...)
+			return
+		}
+	}
+}
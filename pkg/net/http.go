@@ -0,0 +1,232 @@
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tfes-dev/tfes/pkg/auth"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/utils"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	maxHttpBodySize = 1 << 20
+)
+
+var (
+	ClientGoneError = errors.New("client has disconnected")
+)
+
+// httpHeaders are the request headers published along with the body
+var httpHeaders = []string{
+	schemas.HeaderContentType,
+	schemas.HeaderTraceParent,
+	schemas.HeaderTraceState,
+	schemas.HeaderExpectedLastSequence,
+}
+
+// StartHttp serves the HTTP gateway for clients that cannot hold a long-lived TCP connection
+func (pool *TcpHandlerPool) StartHttp() error {
+	httpConfig := pool.config.Http
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/publish/", pool.handleHttpPublish)
+	mux.HandleFunc("/subscribe/", pool.handleHttpSubscribe)
+	mux.HandleFunc("/cluster", pool.handleHttpCluster)
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", httpConfig.Address, httpConfig.Port),
+		Handler: mux,
+	}
+
+	if httpConfig.Tls == nil {
+		return server.ListenAndServe()
+	}
+
+	tlsConfig, err := utils.NewServerTlsConfig(httpConfig.Tls)
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = tlsConfig
+	return server.ListenAndServeTLS("", "")
+}
+
+// handleHttpPublish publishes the request body, as JSON if it is valid JSON or else as a string
+func (pool *TcpHandlerPool) handleHttpPublish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	cc, ok := pool.connectHttpClient(w, r)
+	if !ok {
+		return
+	}
+
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxHttpBodySize))
+	if err != nil {
+		writeHttpAck(w, http.StatusBadRequest, utils.ReturnErrorAck(err))
+		return
+	}
+
+	var body interface{} = string(b)
+	if json.Valid(b) {
+		json.Unmarshal(b, &body)
+	}
+
+	msg := &schemas.Message{
+		Kind: schemas.KindPublish,
+		Publish: &schemas.Publish{
+			Subject: strings.TrimPrefix(r.URL.Path, "/publish/"),
+			ReplyTo: r.URL.Query().Get("reply_to"),
+			Body:    body,
+		},
+	}
+	if messageId := r.Header.Get("X-Message-Id"); len(messageId) > 0 {
+		msg.Header = &schemas.Header{MessageId: messageId}
+	}
+	for _, key := range httpHeaders {
+		if value := r.Header.Get(key); len(value) > 0 {
+			if msg.Publish.Headers == nil {
+				msg.Publish.Headers = make(schemas.Headers)
+			}
+			msg.Publish.Headers.Set(key, value)
+		}
+	}
+
+	response := pool.handlePublish(msg, cc)
+	if !response.Ack.Ok {
+		writeHttpAck(w, refusedStatus(response.Ack), response)
+		return
+	}
+	writeHttpAck(w, http.StatusOK, response)
+}
+
+// handleHttpSubscribe streams the bounties of the subject as Server-Sent Events until the client goes away
+func (pool *TcpHandlerPool) handleHttpSubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := w.(http.Flusher); !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	cc, ok := pool.connectHttpClient(w, r)
+	if !ok {
+		return
+	}
+	cc.ConnectionType = schemas.ConnectionTypeSse
+	cc.ClientGroup = r.URL.Query().Get("group")
+	cc.HttpWriter = w
+
+	subject := strings.TrimPrefix(r.URL.Path, "/subscribe/")
+	if err := auth.CheckSubscribe(cc.User, subject); err != nil {
+		writeHttpAck(w, http.StatusForbidden, utils.ReturnErrorAck(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	cc.WriteLock.Lock()
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	cc.WriteLock.Unlock()
+
+	pool.handleSubscribe(&schemas.Message{
+		Kind:      schemas.KindSubscribe,
+		Subscribe: &schemas.Subscribe{Subject: subject},
+	}, cc)
+
+	<-r.Context().Done()
+	pool.removeClient(cc)
+
+	// Deliveries already in flight must not write to the finished request
+	cc.WriteLock.Lock()
+	cc.HttpWriter = nil
+	cc.WriteLock.Unlock()
+}
+
+// handleHttpCluster returns the state of the routes and of the connection to the hub, for monitoring
+func (pool *TcpHandlerPool) handleHttpCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := pool.connectHttpClient(w, r); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pool.clusterStatus())
+}
+
+// connectHttpClient authenticates the request like a CONNECT, writing an error response on failure
+func (pool *TcpHandlerPool) connectHttpClient(w http.ResponseWriter, r *http.Request) (*schemas.ClientConnection, bool) {
+	var user *schemas.User
+	var err error
+	if mapsUser(pool.config.Http.Tls) && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		user, err = pool.authenticator.AuthenticateCertificate(r.TLS.PeerCertificates[0])
+	} else {
+		user, err = pool.authenticator.Authenticate(httpCredentials(r))
+	}
+
+	if err != nil {
+		writeHttpAck(w, http.StatusUnauthorized, utils.ReturnErrorAck(err))
+		return nil, false
+	}
+
+	return &schemas.ClientConnection{
+		Connected:    true,
+		User:         user,
+		SuppressAcks: true,
+	}, true
+}
+
+// httpCredentials reads basic auth as user credentials, and a bearer token as the shared token
+func httpCredentials(r *http.Request) *schemas.Connect {
+	connect := &schemas.Connect{}
+	if user, password, ok := r.BasicAuth(); ok {
+		connect.User = user
+		connect.Password = password
+	} else if token := r.Header.Get("Authorization"); strings.HasPrefix(token, "Bearer ") {
+		connect.Token = strings.TrimPrefix(token, "Bearer ")
+	}
+	return connect
+}
+
+// refusedStatus tells apart publishes the user may not make from invalid ones
+func refusedStatus(ack *schemas.Ack) int {
+	if strings.HasPrefix(ack.Description, auth.PermissionDeniedError.Error()) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+func writeHttpAck(w http.ResponseWriter, status int, msg *schemas.Message) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(msg)
+}
+
+func writeEvent(w http.ResponseWriter, msg *schemas.Message) error {
+	if w == nil {
+		return ClientGoneError
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
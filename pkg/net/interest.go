@@ -0,0 +1,53 @@
+package net
+
+import (
+	"github.com/tfes-dev/tfes/pkg/schemas"
+)
+
+// subscribePeers counts a local subscription to the subject, and tells the peers about the first one.
+// The lock is held while queuing the message, so that peers hear of the changes of a subject in order.
+func (pool *TcpHandlerPool) subscribePeers(subject string) {
+	pool.interestLock.Lock()
+	defer pool.interestLock.Unlock()
+
+	pool.interest[subject]++
+	if pool.interest[subject] > 1 {
+		return
+	}
+	pool.msgsToPeers <- &schemas.Message{
+		Kind:      schemas.KindSubscribe,
+		Subscribe: &schemas.Subscribe{Subject: subject},
+	}
+}
+
+// subscribeStreams tells the peers about the subjects of the streams, so that the publishes of their clients are stored here too
+func (pool *TcpHandlerPool) subscribeStreams() {
+	if pool.config.Storage == nil {
+		return
+	}
+
+	for _, stream := range pool.config.Storage.Streams {
+		for _, subject := range stream.Subjects {
+			pool.subscribePeers(subject)
+		}
+	}
+}
+
+// unsubscribePeers withdraws the interest of the peers in the subject once no local subscription is left
+func (pool *TcpHandlerPool) unsubscribePeers(subject string) {
+	pool.interestLock.Lock()
+	defer pool.interestLock.Unlock()
+
+	if pool.interest[subject] == 0 {
+		return
+	}
+	pool.interest[subject]--
+	if pool.interest[subject] > 0 {
+		return
+	}
+	delete(pool.interest, subject)
+	pool.msgsToPeers <- &schemas.Message{
+		Kind:        schemas.KindUnsubscribe,
+		Unsubscribe: &schemas.Unsubscribe{Subject: subject},
+	}
+}
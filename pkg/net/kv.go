@@ -0,0 +1,123 @@
+package net
+
+import (
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/auth"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/store"
+	"github.com/tfes-dev/tfes/pkg/utils"
+)
+
+var (
+	BucketNotFoundError = errors.New("bucket not found")
+)
+
+func (pool *TcpHandlerPool) handleKvPut(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	bucket, err := pool.findBucket(msg.Kv, cc, auth.CheckPublish)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	entry, err := bucket.Put(msg.Kv.Key, msg.Kv.Value, msg.Kv.ExpectedRevision)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	return returnRevisionAck(entry)
+}
+
+func (pool *TcpHandlerPool) handleKvDelete(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	bucket, err := pool.findBucket(msg.Kv, cc, auth.CheckPublish)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	entry, err := bucket.Delete(msg.Kv.Key, msg.Kv.ExpectedRevision)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	return returnRevisionAck(entry)
+}
+
+func (pool *TcpHandlerPool) handleKvGet(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	bucket, err := pool.findBucket(msg.Kv, cc, auth.CheckSubscribe)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	entry, err := bucket.Get(msg.Kv.Key)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	return &schemas.Message{Kind: schemas.KindKvEntries, KvEntries: []*schemas.KvEntry{entry}}
+}
+
+func (pool *TcpHandlerPool) handleKvHistory(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	bucket, err := pool.findBucket(msg.Kv, cc, auth.CheckSubscribe)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	entries, err := bucket.History(msg.Kv.Key)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	return &schemas.Message{Kind: schemas.KindKvEntries, KvEntries: entries}
+}
+
+// handleKvWatch sends the client the latest revision of every matching key, then each new revision as it is stored
+func (pool *TcpHandlerPool) handleKvWatch(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	bucket, err := pool.findBucket(msg.Kv, cc, auth.CheckSubscribe)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	watch, err := bucket.Watch(msg.Kv.Key, func(entry *schemas.KvEntry) error {
+		return writeToClient(cc, &schemas.Message{Kind: schemas.KindKvEntries, KvEntries: []*schemas.KvEntry{entry}})
+	})
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	// Watches are replays of the bucket stream, so they are stopped along with the other replays of the client
+	pool.bindingLock.Lock()
+	pool.replays[cc] = append(pool.replays[cc], watch)
+	pool.bindingLock.Unlock()
+	return utils.ReturnSuccessAck()
+}
+
+func (pool *TcpHandlerPool) handleKvUnwatch(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	bucket, err := pool.findBucket(msg.Kv, cc, nil)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	return pool.stopReplay(&schemas.Unsubscribe{
+		Subject: bucket.Subject(msg.Kv.Key),
+		Stream:  bucket.Stream().Name(),
+	}, cc)
+}
+
+// findBucket returns the bucket of the request, once the client is checked to be allowed on the subject of the key
+func (pool *TcpHandlerPool) findBucket(kv *schemas.KvRequest, cc *schemas.ClientConnection, check func(*schemas.User, string) error) (*store.Bucket, error) {
+	if kv == nil {
+		return nil, store.InvalidKeyError
+	}
+
+	bucket := pool.store.Bucket(kv.Bucket)
+	if bucket == nil {
+		return nil, BucketNotFoundError
+	}
+
+	if check != nil {
+		if err := check(cc.User, bucket.Subject(kv.Key)); err != nil {
+			return nil, err
+		}
+	}
+	return bucket, nil
+}
+
+func returnRevisionAck(entry *schemas.KvEntry) *schemas.Message {
+	ack := utils.ReturnSuccessAck()
+	ack.Ack.Sequence = entry.Revision
+	return ack
+}
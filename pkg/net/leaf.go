@@ -0,0 +1,281 @@
+package net
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tfes-dev/tfes/pkg/routing"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/utils"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	LeafAuthenticationError = errors.New("hub refused the leaf connection")
+	LeafDisconnectedError   = errors.New("hub connection lost")
+)
+
+// LeafNode is the connection of a leaf server to its hub.
+// Local publishes to exported subjects are sent to the hub, and the hub's messages on imported subjects are delivered to local clients.
+// The hub knows the connection is a leaf, and does not send the exports back when the leaf also imports their subject.
+type LeafNode struct {
+	config        *schemas.Config
+	msgsToClients chan *schemas.Message
+
+	// conn is the connection to the hub, nil while it is down
+	conn      net.Conn
+	status    *schemas.RouteStatus
+	lock      sync.Mutex
+	writeLock sync.Mutex
+}
+
+func NewLeafNode(config *schemas.Config, msgsToClients chan *schemas.Message) *LeafNode {
+	return &LeafNode{
+		config:        config,
+		msgsToClients: msgsToClients,
+		status:        &schemas.RouteStatus{Url: config.Leaf.Url},
+	}
+}
+
+// supervise keeps the leaf connected to the hub for the life of the server, redialing with a jittered exponential backoff
+func (l *LeafNode) supervise() {
+	backoff := utils.NewBackoff(reconnectWaits(l.config.Leaf.ReconnectWait, l.config.Leaf.MaxReconnectWait))
+	for {
+		l.setState(schemas.RouteStateConnecting, nil)
+		conn, reader, err := l.connect()
+		if err != nil {
+			wait := backoff.Next()
+			log.Printf("Failed to connect to hub %s, retrying in %v: %v\n", l.config.Leaf.Url, wait, err)
+			l.setState(schemas.RouteStateBackingOff, err)
+			time.Sleep(wait)
+			continue
+		}
+
+		l.lock.Lock()
+		l.conn = conn
+		l.lock.Unlock()
+		l.setState(schemas.RouteStateConnected, nil)
+		log.Println("Connected to hub", l.config.Leaf.Url)
+		connectedAt := time.Now()
+
+		l.readFromHub(reader)
+
+		l.lock.Lock()
+		l.conn = nil
+		l.lock.Unlock()
+		conn.Close()
+
+		if time.Since(connectedAt) >= backoff.Max {
+			backoff.Reset()
+		}
+		wait := backoff.Next()
+		log.Printf("Lost hub %s, reconnecting in %v\n", l.config.Leaf.Url, wait)
+		l.setState(schemas.RouteStateBackingOff, LeafDisconnectedError)
+		time.Sleep(wait)
+	}
+}
+
+func (l *LeafNode) dial() (net.Conn, error) {
+	if l.config.Leaf.Tls == nil {
+		return net.Dial("tcp", l.config.Leaf.Url)
+	}
+
+	tlsConfig, err := utils.NewClientTlsConfig(l.config.Leaf.Tls, l.config.Leaf.Url)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Dial("tcp", l.config.Leaf.Url, tlsConfig)
+}
+
+// connect dials the hub, authenticates as a client, and subscribes to the imported subjects
+func (l *LeafNode) connect() (net.Conn, *bufio.Reader, error) {
+	conn, err := l.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	leaf := l.config.Leaf
+	connect := &schemas.Message{
+		Kind: schemas.KindConnect,
+		Connect: &schemas.Connect{
+			User:     leaf.User,
+			Password: leaf.Password,
+			Token:    leaf.Token,
+			ClientID: l.config.Server.Name,
+			Leaf:     true,
+		},
+	}
+	if err := utils.WriteToIo(conn, connect); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(DefaultRequestTimeout))
+	data, err := reader.ReadString('\n')
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	var ack schemas.Message
+	if err := json.Unmarshal([]byte(data), &ack); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if ack.Ack == nil || !ack.Ack.Ok {
+		conn.Close()
+		if ack.Ack != nil {
+			return nil, nil, fmt.Errorf("%w: %s", LeafAuthenticationError, ack.Ack.Description)
+		}
+		return nil, nil, LeafAuthenticationError
+	}
+
+	for _, subject := range leaf.Imports {
+		subscribe := &schemas.Message{
+			Kind:      schemas.KindSubscribe,
+			Subscribe: &schemas.Subscribe{Subject: subject},
+		}
+		if err := utils.WriteToIo(conn, subscribe); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, reader, nil
+}
+
+// readFromHub delivers the imported messages to local clients until the connection is lost
+func (l *LeafNode) readFromHub(reader *bufio.Reader) {
+	for {
+		data, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		var msg schemas.Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			continue
+		}
+
+		switch msg.Kind {
+		case schemas.KindBounty:
+			l.importBounty(&msg)
+			break
+		case schemas.KindAck:
+			if msg.Ack != nil && !msg.Ack.Ok {
+				log.Println("Hub refused leaf message:", msg.Ack.Description)
+			}
+			break
+		}
+	}
+}
+
+// importBounty delivers a message of the hub to local clients, as if it came from a route, so that it is never sent back out
+func (l *LeafNode) importBounty(msg *schemas.Message) {
+	if msg.Bounty == nil {
+		return
+	}
+
+	header := schemas.Header{}
+	if msg.Header != nil {
+		header = *msg.Header
+	}
+	header.Hops = 1
+
+	bounty := msg.Bounty
+	l.msgsToClients <- &schemas.Message{
+		Kind:   schemas.KindPublish,
+		Header: &header,
+		Publish: &schemas.Publish{
+			Subject: bounty.Subject,
+			ReplyTo: bounty.ReplyTo,
+			Body:    bounty.Body,
+			Headers: bounty.Headers,
+		},
+	}
+}
+
+// export sends a local publish to the hub if its subject is exported.
+// Publishes made while the hub is unreachable are only delivered locally.
+func (l *LeafNode) export(msg *schemas.Message) {
+	if !l.Exports(msg.Publish.Subject) {
+		return
+	}
+
+	l.lock.Lock()
+	conn := l.conn
+	l.lock.Unlock()
+	if conn == nil {
+		return
+	}
+
+	header := schemas.Header{}
+	if msg.Header != nil {
+		header = *msg.Header
+	}
+	// The hub sets the origin of the messages of leaf nodes itself
+	header.Origin = ""
+	header.Hops = 0
+	publish := *msg.Publish
+	publish.Headers = withoutReserved(publish.Headers)
+	exported := *msg
+	exported.Header = &header
+	exported.Publish = &publish
+
+	l.writeLock.Lock()
+	defer l.writeLock.Unlock()
+	if err := utils.WriteToIo(conn, &exported); err != nil {
+		conn.Close()
+	}
+}
+
+// withoutReserved drops the reserved headers, which the hub refuses from clients and sets itself
+func withoutReserved(headers schemas.Headers) schemas.Headers {
+	headers = headers.Clone()
+	for key := range headers {
+		if strings.HasPrefix(key, schemas.ReservedHeaderPrefix) {
+			delete(headers, key)
+		}
+	}
+	return headers
+}
+
+// Exports tells whether publishes to the subject are sent to the hub
+func (l *LeafNode) Exports(subject string) bool {
+	for _, export := range l.config.Leaf.Exports {
+		if routing.MatchSubject(subject, export) {
+			return true
+		}
+	}
+	return false
+}
+
+// Connected tells whether the hub is reachable
+func (l *LeafNode) Connected() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.conn != nil
+}
+
+// Status returns the state of the connection to the hub
+func (l *LeafNode) Status() schemas.RouteStatus {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return *l.status
+}
+
+func (l *LeafNode) setState(state string, err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	updateRouteStatus(l.status, state, err)
+}
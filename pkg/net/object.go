@@ -0,0 +1,187 @@
+package net
+
+import (
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/auth"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/store"
+	"github.com/tfes-dev/tfes/pkg/utils"
+)
+
+var (
+	ObjectStoreNotFoundError  = errors.New("object store not found")
+	InvalidObjectRequestError = errors.New("object request is missing")
+)
+
+// handleObjPut stores the data of the request as the next part of the object the client is putting
+func (pool *TcpHandlerPool) handleObjPut(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	objects, err := pool.findObjects(msg.Object, cc, auth.CheckPublish)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	key := objects.Name() + "/" + msg.Object.Name
+	pool.uploadLock.Lock()
+	writer, ok := pool.uploads[cc][key]
+	pool.uploadLock.Unlock()
+
+	if !ok {
+		writer, err = objects.Create(msg.Object.Name)
+		if err != nil {
+			return utils.ReturnErrorAck(err)
+		}
+
+		pool.uploadLock.Lock()
+		if pool.uploads[cc] == nil {
+			pool.uploads[cc] = make(map[string]*store.ObjectWriter)
+		}
+		pool.uploads[cc][key] = writer
+		pool.uploadLock.Unlock()
+	}
+
+	if err := writer.Write(msg.Object.Data); err != nil {
+		pool.takeUpload(cc, key).Abort()
+		return utils.ReturnErrorAck(err)
+	}
+	if !msg.Object.Last {
+		return utils.ReturnSuccessAck()
+	}
+
+	pool.takeUpload(cc, key)
+	info, err := writer.Close()
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	return &schemas.Message{Kind: schemas.KindObjInfos, Objects: []*schemas.ObjectInfo{info}}
+}
+
+// handleObjGet sends the chunks of the object to the client, the last of which carries its info
+func (pool *TcpHandlerPool) handleObjGet(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	objects, err := pool.findObjects(msg.Object, cc, auth.CheckSubscribe)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	// Large objects take a while to send, during which the client's other messages are still handled
+	go func() {
+		index := 0
+		info, err := objects.Get(msg.Object.Name, func(data []byte) error {
+			chunk := &schemas.ObjectChunk{Store: objects.Name(), Name: msg.Object.Name, Index: index, Data: data}
+			index++
+			return writeToClient(cc, &schemas.Message{Kind: schemas.KindObjChunk, ObjectChunk: chunk})
+		})
+		if err != nil {
+			writeToClient(cc, utils.ReturnErrorAck(err))
+			return
+		}
+
+		writeToClient(cc, &schemas.Message{
+			Kind:        schemas.KindObjChunk,
+			ObjectChunk: &schemas.ObjectChunk{Store: objects.Name(), Name: info.Name, Index: index, Last: true, Info: info},
+		})
+	}()
+	return nil
+}
+
+func (pool *TcpHandlerPool) handleObjDelete(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	objects, err := pool.findObjects(msg.Object, cc, auth.CheckPublish)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	if err := objects.Delete(msg.Object.Name); err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	return utils.ReturnSuccessAck()
+}
+
+func (pool *TcpHandlerPool) handleObjList(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	objects, err := pool.findObjects(msg.Object, cc, nil)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	if err := auth.CheckSubscribe(cc.User, objects.WatchSubject()); err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	infos, err := objects.List()
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	return &schemas.Message{Kind: schemas.KindObjInfos, Objects: infos}
+}
+
+// handleObjWatch sends the client the info of every object, then every change as objects are stored or deleted
+func (pool *TcpHandlerPool) handleObjWatch(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	objects, err := pool.findObjects(msg.Object, cc, nil)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	if err := auth.CheckSubscribe(cc.User, objects.WatchSubject()); err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	watch, err := objects.Watch(func(info *schemas.ObjectInfo) error {
+		return writeToClient(cc, &schemas.Message{Kind: schemas.KindObjInfos, Objects: []*schemas.ObjectInfo{info}})
+	})
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	pool.bindingLock.Lock()
+	pool.replays[cc] = append(pool.replays[cc], watch)
+	pool.bindingLock.Unlock()
+	return utils.ReturnSuccessAck()
+}
+
+func (pool *TcpHandlerPool) handleObjUnwatch(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	objects, err := pool.findObjects(msg.Object, cc, nil)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	return pool.stopReplay(&schemas.Unsubscribe{
+		Subject: objects.WatchSubject(),
+		Stream:  objects.Stream().Name(),
+	}, cc)
+}
+
+// findObjects returns the object store of the request, once the client is checked to be allowed on the subject of the object
+func (pool *TcpHandlerPool) findObjects(object *schemas.ObjectRequest, cc *schemas.ClientConnection, check func(*schemas.User, string) error) (*store.ObjectStore, error) {
+	if object == nil {
+		return nil, InvalidObjectRequestError
+	}
+
+	objects := pool.store.Objects(object.Store)
+	if objects == nil {
+		return nil, ObjectStoreNotFoundError
+	}
+
+	if check != nil {
+		if err := check(cc.User, objects.InfoSubject(object.Name)); err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+func (pool *TcpHandlerPool) takeUpload(cc *schemas.ClientConnection, key string) *store.ObjectWriter {
+	pool.uploadLock.Lock()
+	defer pool.uploadLock.Unlock()
+
+	writer := pool.uploads[cc][key]
+	delete(pool.uploads[cc], key)
+	return writer
+}
+
+// abortUploads removes the chunks of the objects a disconnected client was putting
+func (pool *TcpHandlerPool) abortUploads(cc *schemas.ClientConnection) {
+	pool.uploadLock.Lock()
+	uploads := pool.uploads[cc]
+	delete(pool.uploads, cc)
+	pool.uploadLock.Unlock()
+
+	for _, writer := range uploads {
+		writer.Abort()
+	}
+}
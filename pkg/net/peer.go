@@ -2,38 +2,96 @@ package net
 
 import (
 	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/tfes-dev/tfes/pkg/routing"
 	"github.com/tfes-dev/tfes/pkg/schemas"
 	"github.com/tfes-dev/tfes/pkg/utils"
-	"io"
 	"log"
 	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultReconnectWait    = 500 * time.Millisecond
+	DefaultMaxReconnectWait = 30 * time.Second
+
+	// MaxHops is the number of routes a message may cross. Messages received from a route are only delivered to local clients,
+	// which is enough in a full mesh.
+	MaxHops = 1
+)
+
+var (
+	PeerNotConnectedError   = errors.New("peer connect required")
+	PeerAuthenticationError = errors.New("peer authentication failed")
+	PeerDisconnectedError   = errors.New("peer connection lost")
+	DuplicateRouteError     = errors.New("duplicate route to peer")
+	RouteToSelfError        = errors.New("route leads back to this server")
 )
 
 type PeerServer struct {
 	Peers           []*schemas.PeerConnection
+	peerLock        sync.RWMutex
 	config          *schemas.Config
 	msgsFromClients chan *schemas.Message
 	msgsToClients   chan *schemas.Message
+
+	// subscriptions indexes the peers by the subjects they are interested in
+	subscriptions *routing.Sublist
+
+	// interest is the set of subjects local clients are subscribed to, as told by the client pool.
+	// It is only used by the inbox goroutine, which sends it to peers as routes are established.
+	interest map[string]struct{}
+	syncs    chan *schemas.PeerConnection
+
+	// leaf is the connection to the hub, when this server is a leaf node
+	leaf *LeafNode
+
+	// peerRemoved is closed and replaced whenever a peer connection is removed, waking the routes waiting on it
+	peerRemoved chan struct{}
+
+	// routes holds the state of each configured route, in the order of the configuration
+	routes    []*schemas.RouteStatus
+	routeLock sync.Mutex
 }
 
 func NewPeerListener(config *schemas.Config, msgsFromClients chan *schemas.Message, msgsToClients chan *schemas.Message) *PeerServer {
+	var leaf *LeafNode
+	if config.Leaf != nil {
+		leaf = NewLeafNode(config, msgsToClients)
+	}
+
 	return &PeerServer{
+		leaf:            leaf,
 		config:          config,
 		Peers:           make([]*schemas.PeerConnection, 0),
 		msgsFromClients: msgsFromClients,
 		msgsToClients:   msgsToClients,
+		subscriptions:   routing.NewSublist(),
+		interest:        make(map[string]struct{}),
+		syncs:           make(chan *schemas.PeerConnection),
+		peerRemoved:     make(chan struct{}),
 	}
 }
 
 func (p *PeerServer) Start() error {
-	go p.dialPeers()
 	go p.listenToInbox()
+	if p.leaf != nil {
+		go p.leaf.supervise()
+	}
+
+	// Without a cluster there are no routes, but local messages still go through the inbox to a leaf's hub
+	if p.config.Cluster == nil {
+		return nil
+	}
 
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", p.config.Cluster.Address, p.config.Cluster.Port))
+	go p.dialPeers()
+	listener, err := p.listen()
 	if err != nil {
 		return err
 	}
@@ -48,12 +106,39 @@ func (p *PeerServer) Start() error {
 	}
 }
 
+func (p *PeerServer) listen() (net.Listener, error) {
+	addr := fmt.Sprintf("%s:%d", p.config.Cluster.Address, p.config.Cluster.Port)
+	if p.config.Cluster.Tls == nil {
+		return net.Listen("tcp", addr)
+	}
+
+	tlsConfig, err := utils.NewServerTlsConfig(p.config.Cluster.Tls)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", addr, tlsConfig)
+}
+
+func (p *PeerServer) dial(addr string) (net.Conn, error) {
+	if p.config.Cluster.Tls == nil {
+		return net.Dial("tcp", addr)
+	}
+
+	tlsConfig, err := utils.NewClientTlsConfig(p.config.Cluster.Tls, addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Dial("tcp", addr, tlsConfig)
+}
+
 func (p *PeerServer) listenToInbox() {
 	for {
 		select {
 		case msg := <-p.msgsFromClients:
 			log.Println("Received peer inbox msg:", msg.Kind)
 			p.notifyPeers(msg)
+		case pc := <-p.syncs:
+			p.sendInterest(pc)
 		}
 	}
 }
@@ -61,21 +146,246 @@ func (p *PeerServer) listenToInbox() {
 func (p *PeerServer) dialPeers() {
 	for _, route := range p.config.Cluster.Routes {
 		if len(route.Url) > 0 {
-			log.Println("Dialing peer:", route)
-			conn, err := net.Dial("tcp", route.Url)
-			if err == nil {
-				pc := &schemas.PeerConnection{
-					PeerName:      route.Name,
-					PeerUri:       route.Url,
-					TcpConnection: conn,
-				}
-				p.Peers = append(p.Peers, pc)
-				log.Println("Connected to peer", route)
-				p.sendPeerConnectPacket(pc)
-				go p.handleDialedUpConnection(pc)
+			status := &schemas.RouteStatus{Name: route.Name, Url: route.Url}
+			p.routeLock.Lock()
+			p.routes = append(p.routes, status)
+			p.routeLock.Unlock()
+			go p.superviseRoute(route, status)
+		}
+	}
+}
+
+// superviseRoute keeps the route connected, for the life of the server if it is configured.
+// Failed dials and dropped connections are retried after a jittered exponential backoff,
+// which is only reset once a connection has stayed up for as long as the longest wait.
+// Discovered routes are given up once the member is gossiped to have left, or cannot be dialed for too long.
+func (p *PeerServer) superviseRoute(route *schemas.Route, status *schemas.RouteStatus) {
+	backoff := utils.NewBackoff(reconnectWaits(p.config.Cluster.ReconnectWait, p.config.Cluster.MaxReconnectWait))
+	for {
+		if !p.keepRoute(status) {
+			log.Println("Forgetting route to peer:", route)
+			return
+		}
+		// The peer may already be connected by a route it dialed, or by another route to it
+		if removed, ok := p.connectedTo(status); ok {
+			p.setRouteState(status, schemas.RouteStateConnectedByPeer, nil)
+			backoff.Reset()
+			select {
+			case <-removed:
+			case <-time.After(backoff.Max):
 			}
+			continue
+		}
+		p.setRouteState(status, schemas.RouteStateConnecting, nil)
+		log.Println("Dialing peer:", route)
+		conn, err := p.dial(route.Url)
+		if err != nil {
+			wait := backoff.Next()
+			log.Printf("Failed to dial peer %s, retrying in %v: %v\n", route.Url, wait, err)
+			p.setRouteState(status, schemas.RouteStateBackingOff, err)
+			time.Sleep(wait)
+			continue
+		}
+
+		// The dialed peer is trusted, its identity having been verified by TLS if configured
+		pc := &schemas.PeerConnection{
+			Connected:     true,
+			Dialed:        true,
+			PeerName:      route.Name,
+			PeerUri:       route.Url,
+			TcpConnection: conn,
+		}
+		// The PeerConnect must be the first message the peer reads, so the peer is added only once it is sent
+		p.sendPeerConnectPacket(pc)
+		p.addPeer(pc)
+		p.syncs <- pc
+		p.setRouteState(status, schemas.RouteStateConnected, nil)
+		log.Println("Connected to peer", route)
+		connectedAt := time.Now()
+		p.handleDialedUpConnection(pc)
+		if pc.PeerName == p.config.Server.Name {
+			log.Println("Giving up route to this server:", route)
+			p.dropRoute(status)
+			return
+		}
+		p.learnRouteName(status, pc.PeerName)
+
+		if time.Since(connectedAt) >= backoff.Max {
+			backoff.Reset()
+		}
+		wait := backoff.Next()
+		log.Printf("Lost peer %s, reconnecting in %v\n", route.Url, wait)
+		p.setRouteState(status, schemas.RouteStateBackingOff, PeerDisconnectedError)
+		time.Sleep(wait)
+	}
+}
+
+// reconnectWaits converts the configured waits in milliseconds, falling back to the defaults
+func reconnectWaits(wait int, maxWait int) (time.Duration, time.Duration) {
+	initial, max := DefaultReconnectWait, DefaultMaxReconnectWait
+	if wait > 0 {
+		initial = time.Duration(wait) * time.Millisecond
+	}
+	if maxWait > 0 {
+		max = time.Duration(maxWait) * time.Millisecond
+	}
+	if max < initial {
+		max = initial
+	}
+	return initial, max
+}
+
+// connectedTo tells whether the peer of the route is connected by another connection. Only routes whose peer name is known are checked.
+// The returned channel is closed once a peer connection is removed, for the route to check again.
+func (p *PeerServer) connectedTo(status *schemas.RouteStatus) (<-chan struct{}, bool) {
+	p.routeLock.Lock()
+	name := status.Name
+	p.routeLock.Unlock()
+	if len(name) == 0 {
+		return nil, false
+	}
+
+	p.peerLock.RLock()
+	defer p.peerLock.RUnlock()
+	return p.peerRemoved, p.findPeer(name) != nil
+}
+
+// learnRouteName records the name a peer gave in its PeerConnect, for routes configured without one
+func (p *PeerServer) learnRouteName(status *schemas.RouteStatus, name string) {
+	p.routeLock.Lock()
+	defer p.routeLock.Unlock()
+
+	if len(status.Name) == 0 {
+		status.Name = name
+	}
+}
+
+func (p *PeerServer) setRouteState(status *schemas.RouteStatus, state string, err error) {
+	p.routeLock.Lock()
+	defer p.routeLock.Unlock()
+
+	updateRouteStatus(status, state, err)
+}
+
+// updateRouteStatus moves the route to the state, counting failures until it is connected
+func updateRouteStatus(status *schemas.RouteStatus, state string, err error) {
+	if state == schemas.RouteStateBackingOff {
+		status.Failures++
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	if state == schemas.RouteStateConnected || state == schemas.RouteStateConnectedByPeer {
+		status.Failures = 0
+		status.LastError = ""
+	}
+	status.State = state
+	status.Since = time.Now()
+}
+
+// Routes returns the current state of the configured and discovered routes
+func (p *PeerServer) Routes() []schemas.RouteStatus {
+	p.routeLock.Lock()
+	defer p.routeLock.Unlock()
+
+	routes := make([]schemas.RouteStatus, 0, len(p.routes))
+	for _, status := range p.routes {
+		routes = append(routes, *status)
+	}
+	return routes
+}
+
+func (p *PeerServer) addPeer(pc *schemas.PeerConnection) {
+	p.peerLock.Lock()
+	defer p.peerLock.Unlock()
+
+	p.Peers = append(p.Peers, pc)
+}
+
+// removePeer prunes a peer whose connection was lost, along with the subjects it was interested in.
+// The other members are told it left once no connection to it remains.
+func (p *PeerServer) removePeer(pc *schemas.PeerConnection) {
+	p.peerLock.Lock()
+	for i, peer := range p.Peers {
+		if peer == pc {
+			p.Peers = append(p.Peers[:i], p.Peers[i+1:]...)
+			break
+		}
+	}
+	for _, subject := range pc.InterestedSubjects {
+		p.subscriptions.Remove(subject, pc)
+	}
+	pc.InterestedSubjects = nil
+	left := p.findPeer(pc.PeerName) == nil
+	close(p.peerRemoved)
+	p.peerRemoved = make(chan struct{})
+	p.peerLock.Unlock()
+
+	if left {
+		p.gossipLeft(pc)
+	}
+}
+
+// dedupeRoute keeps a single connection to each peer, and tells whether the connection is the one kept.
+// Of two connections in opposite directions, both servers keep the one dialed by the server with the lesser name.
+// A server which dialed the same peer twice closes the newer connection, and the peer sees it drop.
+func (p *PeerServer) dedupeRoute(pc *schemas.PeerConnection) bool {
+	p.peerLock.Lock()
+	var other *schemas.PeerConnection
+	for _, peer := range p.Peers {
+		if peer != pc && peer.PeerName == pc.PeerName && !peer.Duplicate {
+			other = peer
+			break
+		}
+	}
+	if other == nil || (!pc.Dialed && !other.Dialed) {
+		p.peerLock.Unlock()
+		return true
+	}
+
+	closed := pc
+	if pc.Dialed != other.Dialed {
+		keepDialed := p.config.Server.Name < pc.PeerName
+		if pc.Dialed == keepDialed {
+			closed = other
+		}
+	}
+	closed.Duplicate = true
+	p.peerLock.Unlock()
+
+	log.Printf("Closing duplicate route to peer %s\n", pc.PeerName)
+	closed.TcpConnection.Close()
+	return closed != pc
+}
+
+// findPeer returns a connection to the named peer, if any. The peer lock must be held.
+func (p *PeerServer) findPeer(name string) *schemas.PeerConnection {
+	for _, peer := range p.Peers {
+		if peer.PeerName == name && !peer.Duplicate {
+			return peer
 		}
 	}
+	return nil
+}
+
+// writeToPeer sends the message to the peer, closing the connection if it fails so that the peer is pruned
+func writeToPeer(pc *schemas.PeerConnection, msg *schemas.Message) error {
+	pc.WriteLock.Lock()
+	defer pc.WriteLock.Unlock()
+
+	err := utils.WriteToIo(pc.TcpConnection, msg)
+	if err != nil {
+		pc.TcpConnection.Close()
+	}
+	return err
+}
+
+// advertiseAddr is the address other members dial to reach this server
+func (p *PeerServer) advertiseAddr() string {
+	if len(p.config.Cluster.Advertise) > 0 {
+		return p.config.Cluster.Advertise
+	}
+	return fmt.Sprintf("%s:%d", p.config.Cluster.Address, p.config.Cluster.Port)
 }
 
 func (p *PeerServer) sendPeerConnectPacket(connection *schemas.PeerConnection) {
@@ -83,10 +393,11 @@ func (p *PeerServer) sendPeerConnectPacket(connection *schemas.PeerConnection) {
 		Kind: schemas.KindPeerConnect,
 		PeerConnect: &schemas.PeerConnect{
 			PeerName:      p.config.Server.Name,
-			AdvertiseAddr: fmt.Sprintf("%s:%d", p.config.Cluster.Address, p.config.Cluster.Port),
+			AdvertiseAddr: p.advertiseAddr(),
+			Secret:        p.config.Cluster.Secret,
 		},
 	}
-	utils.WriteToIo(connection.TcpConnection, msg)
+	writeToPeer(connection, msg)
 }
 
 func (p *PeerServer) handleConnection(conn net.Conn) {
@@ -98,36 +409,31 @@ func (p *PeerServer) handleConnection(conn net.Conn) {
 		data, err := reader.ReadString('\n')
 
 		if err != nil {
-			if err == io.EOF {
-				conn.Close()
-				return
+			conn.Close()
+			if cc.Connected {
+				log.Println("Lost peer", cc.PeerName)
+				p.removePeer(cc)
 			}
-			continue
+			return
 		}
 
 		p.handleIncomingMessage(data, cc)
 	}
 }
 
+// handleDialedUpConnection reads from the dialed peer until the connection is lost, then prunes it
 func (p *PeerServer) handleDialedUpConnection(pc *schemas.PeerConnection) {
 	reader := bufio.NewReader(pc.TcpConnection)
-	cc := &schemas.PeerConnection{
-		PeerName:      pc.PeerName,
-		PeerUri:       pc.PeerUri,
-		TcpConnection: pc.TcpConnection,
-	}
 	for {
 		data, err := reader.ReadString('\n')
 
 		if err != nil {
-			if err == io.EOF {
-				pc.TcpConnection.Close()
-				return
-			}
-			continue
+			pc.TcpConnection.Close()
+			p.removePeer(pc)
+			return
 		}
 
-		p.handleIncomingMessage(data, cc)
+		p.handleIncomingMessage(data, pc)
 	}
 }
 
@@ -139,6 +445,11 @@ func (p *PeerServer) handleIncomingMessage(data string, cc *schemas.PeerConnecti
 		return utils.ReturnErrorAck(err)
 	}
 
+	// Nothing but a PeerConnect is accepted until the peer has been verified
+	if msg.Kind != schemas.KindPeerConnect && !cc.Connected {
+		return utils.ReturnErrorAck(PeerNotConnectedError)
+	}
+
 	var fn func(*schemas.Message, *schemas.PeerConnection) *schemas.Message
 
 	switch msg.Kind {
@@ -154,6 +465,12 @@ func (p *PeerServer) handleIncomingMessage(data string, cc *schemas.PeerConnecti
 	case schemas.KindUnsubscribe:
 		fn = p.handleUnsubscribe
 		break
+	case schemas.KindPeerInterest:
+		fn = p.handlePeerInterest
+		break
+	case schemas.KindPeerGossip:
+		fn = p.handlePeerGossip
+		break
 	default:
 		return utils.ReturnErrorAck(errors.New("unknown message kind"))
 	}
@@ -162,23 +479,108 @@ func (p *PeerServer) handleIncomingMessage(data string, cc *schemas.PeerConnecti
 }
 
 func (p *PeerServer) notifyPeers(msg *schemas.Message) {
-	for _, peer := range p.Peers {
-		if msg.Kind == schemas.KindSubscribe || msg.Kind == schemas.KindUnsubscribe {
-			log.Println("Notifying peer:", peer.PeerUri)
-			utils.WriteToIo(peer.TcpConnection, msg)
+	if msg.Kind == schemas.KindSubscribe || msg.Kind == schemas.KindUnsubscribe {
+		if msg.Kind == schemas.KindSubscribe {
+			p.interest[msg.Subscribe.Subject] = struct{}{}
 		} else {
-			log.Printf("Checking %s for subs: %v\n", peer.PeerName, peer.InterestedSubjects)
-			for _, sub := range peer.InterestedSubjects {
-				if routing.MatchSubject(msg.Publish.Subject, sub) {
-					log.Println("Notifying peer:", peer.PeerUri)
-					utils.WriteToIo(peer.TcpConnection, msg)
-				}
-			}
+			delete(p.interest, msg.Unsubscribe.Subject)
+		}
+
+		p.peerLock.RLock()
+		peers := append([]*schemas.PeerConnection(nil), p.Peers...)
+		p.peerLock.RUnlock()
+
+		for _, peer := range peers {
+			log.Println("Notifying peer:", peer.PeerUri)
+			writeToPeer(peer, msg)
 		}
+		return
+	}
+
+	// Messages received from a route or the hub are never forwarded to routes again
+	if msg.Header != nil && msg.Header.Hops > 0 {
+		return
+	}
+	if p.leaf != nil {
+		p.leaf.export(msg)
+	}
+
+	header := schemas.Header{}
+	if msg.Header != nil {
+		header = *msg.Header
+	}
+	header.Origin = p.config.Server.Name
+	header.Hops = 1
+	forwarded := *msg
+	forwarded.Header = &header
+
+	// Each peer is sent the message once, even while it is connected by more than one route
+	notified := make(map[string]bool)
+	peers := make([]*schemas.PeerConnection, 0)
+	p.peerLock.RLock()
+	for _, sub := range p.subscriptions.Match(msg.Publish.Subject) {
+		peer := sub.(*schemas.PeerConnection)
+		if peer.Duplicate || notified[peer.PeerName] {
+			continue
+		}
+		notified[peer.PeerName] = true
+		peers = append(peers, peer)
+	}
+	p.peerLock.RUnlock()
+
+	for _, peer := range peers {
+		log.Println("Notifying peer:", peer.PeerUri)
+		writeToPeer(peer, &forwarded)
 	}
 }
 
+// sendInterest sends a newly established peer the subjects of all local subscriptions.
+// It runs on the inbox goroutine, so that the subscriptions and unsubscriptions which follow reach the peer after it.
+func (p *PeerServer) sendInterest(pc *schemas.PeerConnection) {
+	subjects := make([]string, 0, len(p.interest))
+	for subject := range p.interest {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+
+	log.Printf("Sending %d subjects of interest to peer %s\n", len(subjects), pc.PeerUri)
+	writeToPeer(pc, &schemas.Message{
+		Kind:         schemas.KindPeerInterest,
+		PeerInterest: &schemas.PeerInterest{Subjects: subjects},
+	})
+}
+
+// Interested tells if any peer has subscribed to the subject, or if it is exported to a connected hub
+func (p *PeerServer) Interested(subject string) bool {
+	if p.leaf != nil && p.leaf.Connected() && p.leaf.Exports(subject) {
+		return true
+	}
+	return len(p.subscriptions.Match(subject)) > 0
+}
+
+// Status returns the state of the routes, and of the connection to the hub if this server is a leaf node
+func (p *PeerServer) Status() *schemas.ClusterStatus {
+	status := &schemas.ClusterStatus{Routes: p.Routes()}
+	if p.leaf != nil {
+		leaf := p.leaf.Status()
+		status.Leaf = &leaf
+	}
+	return status
+}
+
+// handlePublish delivers a message from a route to local clients, unless it looped back to this server or crossed too many routes
 func (p *PeerServer) handlePublish(message *schemas.Message, connection *schemas.PeerConnection) *schemas.Message {
+	if message.Header == nil {
+		message.Header = &schemas.Header{}
+	}
+	if message.Header.Origin == p.config.Server.Name || message.Header.Hops > MaxHops {
+		log.Printf("Dropping message from %s which crossed %d routes from %s\n", connection.PeerName, message.Header.Hops, message.Header.Origin)
+		return utils.ReturnSuccessAck()
+	}
+	if message.Header.Hops == 0 {
+		message.Header.Hops = 1
+	}
+
 	p.msgsToClients <- message
 	return utils.ReturnSuccessAck()
 }
@@ -186,31 +588,137 @@ func (p *PeerServer) handlePublish(message *schemas.Message, connection *schemas
 func (p *PeerServer) handleSubscribe(message *schemas.Message, connection *schemas.PeerConnection) *schemas.Message {
 	subscribe := message.Subscribe
 	log.Println("Received peer subscribe for:", message.Subscribe.Subject)
+	p.peerLock.Lock()
+	defer p.peerLock.Unlock()
 	for _, peer := range p.Peers {
 		if peer.PeerName == connection.PeerName {
-			peer.InterestedSubjects = append(peer.InterestedSubjects, subscribe.Subject)
+			p.addInterest(peer, subscribe.Subject)
 		}
 	}
 	return utils.ReturnSuccessAck()
 }
 
 func (p *PeerServer) handleUnsubscribe(message *schemas.Message, connection *schemas.PeerConnection) *schemas.Message {
-	subscribe := message.Subscribe
-	peerUrl := connection.TcpConnection.RemoteAddr().String()
+	unsubscribe := message.Unsubscribe
+	p.peerLock.Lock()
+	defer p.peerLock.Unlock()
 	for _, peer := range p.Peers {
-		if peer.PeerUri == peerUrl {
-			peer.InterestedSubjects = utils.RemoveItem(peer.InterestedSubjects, subscribe.Subject)
+		if peer.PeerName == connection.PeerName {
+			p.removeInterest(peer, unsubscribe.Subject)
 		}
 	}
 	return utils.ReturnSuccessAck()
 }
 
+// handlePeerInterest replaces the interest of the peer with the snapshot it sent
+func (p *PeerServer) handlePeerInterest(message *schemas.Message, connection *schemas.PeerConnection) *schemas.Message {
+	subjects := make(map[string]bool)
+	for _, subject := range message.PeerInterest.Subjects {
+		subjects[subject] = true
+	}
+	log.Printf("Received %d subjects of interest from peer %s\n", len(subjects), connection.PeerName)
+
+	p.peerLock.Lock()
+	defer p.peerLock.Unlock()
+	for _, peer := range p.Peers {
+		if peer.PeerName != connection.PeerName {
+			continue
+		}
+		for _, subject := range append([]string(nil), peer.InterestedSubjects...) {
+			if !subjects[subject] {
+				p.removeInterest(peer, subject)
+			}
+		}
+		for subject := range subjects {
+			p.addInterest(peer, subject)
+		}
+	}
+	return utils.ReturnSuccessAck()
+}
+
+// addInterest records that the peer is interested in the subject. Interest is a set, since peers only send the first subscription of each subject.
+// The peer lock must be held.
+func (p *PeerServer) addInterest(peer *schemas.PeerConnection, subject string) {
+	if utils.ContainsItem(peer.InterestedSubjects, subject) {
+		return
+	}
+	peer.InterestedSubjects = append(peer.InterestedSubjects, subject)
+	p.subscriptions.Insert(subject, peer)
+}
+
+// removeInterest is the counterpart of addInterest. The peer lock must be held.
+func (p *PeerServer) removeInterest(peer *schemas.PeerConnection, subject string) {
+	if !utils.ContainsItem(peer.InterestedSubjects, subject) {
+		return
+	}
+	peer.InterestedSubjects = utils.RemoveItem(peer.InterestedSubjects, subject)
+	p.subscriptions.Remove(subject, peer)
+}
+
 func (p *PeerServer) handlePeerConnect(message *schemas.Message, connection *schemas.PeerConnection) *schemas.Message {
 	log.Println("Received incoming peer connection")
 	pc := message.PeerConnect
+	if err := p.verifyPeer(pc, connection); err != nil {
+		log.Println("Rejected peer:", err)
+		connection.TcpConnection.Close()
+		return utils.ReturnErrorAck(err)
+	}
+
+	if pc.PeerName == p.config.Server.Name {
+		// The name tells the supervisor of a dialed route to give it up, so the accepting side answers with it first
+		if !connection.Connected {
+			p.sendPeerConnectPacket(connection)
+		}
+		p.peerLock.Lock()
+		connection.PeerName = pc.PeerName
+		p.peerLock.Unlock()
+		log.Println("Rejected peer:", RouteToSelfError)
+		connection.TcpConnection.Close()
+		return utils.ReturnErrorAck(RouteToSelfError)
+	}
+
+	// A dialed peer answers our PeerConnect with its own, telling its name and the address it advertises
+	if connection.Connected {
+		p.peerLock.Lock()
+		connection.PeerName = pc.PeerName
+		connection.PeerUri = pc.AdvertiseAddr
+		p.peerLock.Unlock()
+		if p.dedupeRoute(connection) {
+			p.gossipJoined(connection)
+		}
+		return utils.ReturnSuccessAck()
+	}
+
+	connection.Connected = true
 	connection.PeerName = pc.PeerName
 	connection.PeerUri = pc.AdvertiseAddr
+	if !p.dedupeRoute(connection) {
+		return utils.ReturnErrorAck(DuplicateRouteError)
+	}
 
-	p.Peers = append(p.Peers, connection)
+	p.sendPeerConnectPacket(connection)
+	p.addPeer(connection)
+	p.syncs <- connection
+	p.gossipJoined(connection)
 	return utils.ReturnSuccessAck()
 }
+
+// verifyPeer checks the cluster secret, and that a verified TLS certificate naming the peer was presented if required
+func (p *PeerServer) verifyPeer(pc *schemas.PeerConnect, connection *schemas.PeerConnection) error {
+	secret := p.config.Cluster.Secret
+	if len(secret) > 0 && subtle.ConstantTimeCompare([]byte(secret), []byte(pc.Secret)) != 1 {
+		return PeerAuthenticationError
+	}
+
+	if tlsConfig := p.config.Cluster.Tls; tlsConfig != nil && tlsConfig.VerifyClient {
+		cert := utils.PeerCertificate(connection.TcpConnection)
+		if cert == nil {
+			return PeerAuthenticationError
+		}
+		// Otherwise any certificate signed by the CA would let one server claim the name of another
+		if cert.Subject.CommonName != pc.PeerName && cert.VerifyHostname(pc.PeerName) != nil {
+			return fmt.Errorf("%w: certificate does not name peer %s", PeerAuthenticationError, pc.PeerName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package net
+
+import (
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/store"
+	"github.com/tfes-dev/tfes/pkg/utils"
+	"log"
+)
+
+// startScheduler publishes the scheduled messages as they come due
+func (pool *TcpHandlerPool) startScheduler() {
+	scheduler := pool.store.Scheduler()
+	if scheduler == nil {
+		return
+	}
+
+	scheduler.Start(func(scheduled *schemas.Scheduled) {
+		response := pool.publish(&schemas.Message{
+			Kind:    schemas.KindPublish,
+			Header:  scheduled.Header,
+			Publish: scheduled.Publish,
+		})
+		if !response.Ack.Ok {
+			log.Printf("Failed to publish scheduled message %s: %s\n", scheduled.Id, response.Ack.Description)
+		}
+	})
+}
+
+// schedule holds the publish until it is due, acknowledging it with the id to cancel it with
+func (pool *TcpHandlerPool) schedule(msg *schemas.Message) *schemas.Message {
+	scheduler := pool.store.Scheduler()
+	if scheduler == nil {
+		return utils.ReturnErrorAck(store.SchedulingDisabledError)
+	}
+
+	scheduled, err := scheduler.Schedule(msg.Publish, msg.Header)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	ack := utils.ReturnSuccessAck()
+	ack.Ack.Id = scheduled.Id
+	return ack
+}
+
+func (pool *TcpHandlerPool) handleCancel(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	scheduler := pool.store.Scheduler()
+	if scheduler == nil {
+		return utils.ReturnErrorAck(store.SchedulingDisabledError)
+	}
+	if msg.Cancel == nil {
+		return utils.ReturnErrorAck(store.ScheduleNotFoundError)
+	}
+
+	if err := scheduler.Cancel(msg.Cancel.Id); err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	return utils.ReturnSuccessAck()
+}
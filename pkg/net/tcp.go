@@ -2,40 +2,125 @@ package net
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/tfes-dev/tfes/pkg/auth"
 	"github.com/tfes-dev/tfes/pkg/routing"
 	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/store"
 	"github.com/tfes-dev/tfes/pkg/utils"
-	"io"
 	"log"
 	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRequestTimeout = 5 * time.Second
+)
+
+var (
+	RequestTimeoutError   = errors.New("request timed out")
+	NotConnectedError     = errors.New("connect required")
+	NoSubscribersError    = errors.New("no subscribers for the guaranteed message")
+	AlreadyConnectedError = errors.New("already connected")
+	LeafNameRequiredError = errors.New("leaf nodes must connect with their server name as client id")
+	ReservedSubjectError  = errors.New("subject is reserved")
 )
 
 type TcpHandlerPool struct {
 	// Clients only has a list of clients who have subscribed to at least one topic
 	// Other clients who are simply connected need not be tracked, for now.
-	Clients []*schemas.ClientConnection
+	Clients    []*schemas.ClientConnection
+	clientLock sync.Mutex
 
 	msgsToPeers   chan *schemas.Message
 	msgsFromPeers chan *schemas.Message
 	config        *schemas.Config
+	authenticator *auth.Authenticator
+	store         *store.Store
+
+	// subscriptions indexes the subscribed clients by subject
+	subscriptions *routing.Sublist
+
+	// filters holds the filter of each subscription of a client by subject, nil for subscriptions without one
+	filters    map[*schemas.ClientConnection]map[string]*routing.Filter
+	filterLock sync.RWMutex
+
+	// groupCursors tracks the round-robin position of each client group
+	groupCursors map[string]int
+	groupLock    sync.Mutex
+
+	// requests holds the requests waiting for a reply, keyed by their inbox subject
+	requests    map[string]*pendingRequest
+	requestLock sync.Mutex
+
+	// bindings holds the durable consumers each client is bound to, and replays the stream replays it receives
+	bindings    map[*schemas.ClientConnection][]*store.Consumer
+	replays     map[*schemas.ClientConnection][]*store.Replay
+	bindingLock sync.Mutex
+
+	// uploads holds the objects each client is in the middle of putting, keyed by store and object name
+	uploads    map[*schemas.ClientConnection]map[string]*store.ObjectWriter
+	uploadLock sync.Mutex
+
+	// duplicates is nil unless a server wide duplicate window is configured
+	duplicates *utils.DuplicateWindow
+
+	// interest counts the subscriptions of local clients and requests by subject, so that peers hear of each subject once
+	interest     map[string]int
+	interestLock sync.Mutex
+
+	// peersInterested tells if any peer has subscribed to a subject
+	peersInterested func(subject string) bool
+
+	// clusterStatus returns the state of the routes and of the connection to the hub
+	clusterStatus func() *schemas.ClusterStatus
+}
+
+type pendingRequest struct {
+	header *schemas.Header
+	client *schemas.ClientConnection
+	timer  *time.Timer
 }
 
-func NewTcpHandlerPool(config *schemas.Config, msgsToPeers chan *schemas.Message, msgsFromPeers chan *schemas.Message) *TcpHandlerPool {
+func NewTcpHandlerPool(config *schemas.Config, messageStore *store.Store, msgsToPeers chan *schemas.Message, msgsFromPeers chan *schemas.Message, peersInterested func(subject string) bool, clusterStatus func() *schemas.ClusterStatus) *TcpHandlerPool {
+	var duplicates *utils.DuplicateWindow
+	if config.Server.DuplicateWindow > 0 {
+		duplicates = utils.NewDuplicateWindow(time.Duration(config.Server.DuplicateWindow) * time.Millisecond)
+	}
+
 	return &TcpHandlerPool{
-		config:        config,
-		msgsToPeers:   msgsToPeers,
-		msgsFromPeers: msgsFromPeers,
-		Clients:       make([]*schemas.ClientConnection, 0),
+		config:          config,
+		store:           messageStore,
+		msgsToPeers:     msgsToPeers,
+		msgsFromPeers:   msgsFromPeers,
+		authenticator:   auth.NewAuthenticator(config.Authorization),
+		Clients:         make([]*schemas.ClientConnection, 0),
+		subscriptions:   routing.NewSublist(),
+		filters:         make(map[*schemas.ClientConnection]map[string]*routing.Filter),
+		interest:        make(map[string]int),
+		groupCursors:    make(map[string]int),
+		requests:        make(map[string]*pendingRequest),
+		bindings:        make(map[*schemas.ClientConnection][]*store.Consumer),
+		replays:         make(map[*schemas.ClientConnection][]*store.Replay),
+		uploads:         make(map[*schemas.ClientConnection]map[string]*store.ObjectWriter),
+		duplicates:      duplicates,
+		peersInterested: peersInterested,
+		clusterStatus:   clusterStatus,
 	}
 }
 
 func (pool *TcpHandlerPool) Start() error {
 	go pool.listenToInbox()
+	pool.subscribeStreams()
+	pool.startScheduler()
+	pool.store.OnDeadLetter(pool.publishDeadLetter)
 
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", pool.config.Server.Address, pool.config.Server.Port))
+	listener, err := pool.listen()
 	if err != nil {
 		return err
 	}
@@ -50,41 +135,52 @@ func (pool *TcpHandlerPool) Start() error {
 	}
 }
 
+func (pool *TcpHandlerPool) listen() (net.Listener, error) {
+	addr := fmt.Sprintf("%s:%d", pool.config.Server.Address, pool.config.Server.Port)
+	if pool.config.Server.Tls == nil {
+		return net.Listen("tcp", addr)
+	}
+
+	tlsConfig, err := utils.NewServerTlsConfig(pool.config.Server.Tls)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", addr, tlsConfig)
+}
+
 func (pool *TcpHandlerPool) listenToInbox() {
 	for {
 		select {
 		case msg := <-pool.msgsFromPeers:
 			log.Println("Going to broadcast peer message to clients:", msg.Kind)
-			for _, _cc := range pool.Clients {
-				go func(_cc *schemas.ClientConnection) {
-					checkAndSendToClient(msg, _cc)
-				}(_cc)
-			}
+			pool.capture(msg)
+			// The server the message was published on already picked a member of its client groups
+			pool.broadcast(msg, false)
 		}
 	}
 }
 
 func (pool *TcpHandlerPool) handleConnection(conn net.Conn) {
-	reader, writer := bufio.NewReader(conn), bufio.NewWriter(conn)
+	reader := bufio.NewReader(conn)
 	cc := &schemas.ClientConnection{
 		ConnectionType: schemas.ConnectionTypeTcp,
 		TcpConnection:  conn,
+		MapUser:        mapsUser(pool.config.Server.Tls),
 	}
 	for {
 		data, err := reader.ReadString('\n')
 
 		if err != nil {
-			if err == io.EOF {
-				conn.Close()
-				return
-			}
-			continue
+			pool.removeClient(cc)
+			conn.Close()
+			return
 		}
 
 		response := pool.handleIncomingMessage(data, cc)
 
-		if !cc.SuppressAcks {
-			utils.WriteToBufio(writer, response)
+		// Requests are answered asynchronously with either a reply or an error Ack
+		if response != nil && !(cc.SuppressAcks && response.Kind == schemas.KindAck) {
+			writeToClient(cc, response)
 		}
 	}
 }
@@ -97,6 +193,10 @@ func (pool *TcpHandlerPool) handleIncomingMessage(data string, cc *schemas.Clien
 		return utils.ReturnErrorAck(err)
 	}
 
+	if msg.Kind != schemas.KindConnect && !cc.Connected {
+		return utils.ReturnErrorAck(NotConnectedError)
+	}
+
 	var fn func(*schemas.Message, *schemas.ClientConnection) *schemas.Message
 
 	switch msg.Kind {
@@ -112,6 +212,51 @@ func (pool *TcpHandlerPool) handleIncomingMessage(data string, cc *schemas.Clien
 	case schemas.KindUnsubscribe:
 		fn = pool.handleUnsubscribe
 		break
+	case schemas.KindRequest:
+		fn = pool.handleRequest
+		break
+	case schemas.KindMessageAck:
+		fn = pool.handleMessageAck
+		break
+	case schemas.KindKvPut:
+		fn = pool.handleKvPut
+		break
+	case schemas.KindKvGet:
+		fn = pool.handleKvGet
+		break
+	case schemas.KindKvDelete:
+		fn = pool.handleKvDelete
+		break
+	case schemas.KindKvHistory:
+		fn = pool.handleKvHistory
+		break
+	case schemas.KindKvWatch:
+		fn = pool.handleKvWatch
+		break
+	case schemas.KindKvUnwatch:
+		fn = pool.handleKvUnwatch
+		break
+	case schemas.KindObjPut:
+		fn = pool.handleObjPut
+		break
+	case schemas.KindObjGet:
+		fn = pool.handleObjGet
+		break
+	case schemas.KindObjDelete:
+		fn = pool.handleObjDelete
+		break
+	case schemas.KindObjList:
+		fn = pool.handleObjList
+		break
+	case schemas.KindObjWatch:
+		fn = pool.handleObjWatch
+		break
+	case schemas.KindObjUnwatch:
+		fn = pool.handleObjUnwatch
+		break
+	case schemas.KindCancel:
+		fn = pool.handleCancel
+		break
 	default:
 		return utils.ReturnErrorAck(errors.New("unknown message kind"))
 	}
@@ -121,55 +266,421 @@ func (pool *TcpHandlerPool) handleIncomingMessage(data string, cc *schemas.Clien
 
 func (pool *TcpHandlerPool) handleConnect(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
 	connect := msg.Connect
+	if cc.Connected {
+		return utils.ReturnErrorAck(AlreadyConnectedError)
+	}
+
+	user, err := pool.authenticate(connect, cc)
+	if err != nil {
+		// The ack is written regardless of SuppressAcks, since the connection is closed right after
+		log.Println("Rejected client:", err)
+		writeToClient(cc, utils.ReturnErrorAck(err))
+		cc.TcpConnection.Close()
+		return nil
+	}
+
+	if connect.Leaf {
+		if len(connect.ClientID) == 0 {
+			return utils.ReturnErrorAck(LeafNameRequiredError)
+		}
+		if err := auth.CheckLeaf(user); err != nil {
+			return utils.ReturnErrorAck(err)
+		}
+		cc.LeafName = connect.ClientID
+	}
+
+	cc.User = user
+	cc.Connected = true
 	if len(connect.ClientGroup) > 0 {
 		cc.ClientUri = fmt.Sprintf("%s:%s", connect.ClientID, connect.ClientGroup)
 	} else {
 		cc.ClientUri = connect.ClientID
 	}
+	cc.ClientGroup = connect.ClientGroup
 	cc.SuppressAcks = connect.SuppressAcks
+	pool.clientLock.Lock()
 	pool.Clients = append(pool.Clients, cc)
+	pool.clientLock.Unlock()
 	log.Println("Connected new client")
 	return utils.ReturnSuccessAck()
 }
 
+// authenticate identifies the client by its certificate if its listener is configured to, or else by its CONNECT credentials
+func (pool *TcpHandlerPool) authenticate(connect *schemas.Connect, cc *schemas.ClientConnection) (*schemas.User, error) {
+	if cc.MapUser {
+		if cert := utils.PeerCertificate(cc.TcpConnection); cert != nil {
+			return pool.authenticator.AuthenticateCertificate(cert)
+		}
+	}
+	return pool.authenticator.Authenticate(connect)
+}
+
+// mapsUser tells if the listener authenticates clients by the user named in their certificate
+func mapsUser(tlsConfig *schemas.Tls) bool {
+	return tlsConfig != nil && tlsConfig.MapUser
+}
+
 func (pool *TcpHandlerPool) handlePublish(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	if err := auth.CheckPublish(cc.User, msg.Publish.Subject); err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	if err := checkSubject(msg.Publish.Subject); err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	headers, err := pool.checkHeaders(msg.Publish.Headers)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	msg.Publish.Headers = headers
+	msg.Header = clientHeader(msg.Header, cc)
+
+	if msg.Publish.IsScheduled() {
+		return pool.schedule(msg)
+	}
+	return pool.publish(msg)
+}
+
+// publish stores the message in the interested streams, then delivers it to clients and peers
+func (pool *TcpHandlerPool) publish(msg *schemas.Message) *schemas.Message {
+	msg.Publish.Headers = timestamped(msg.Publish.Headers)
+
+	messageId := ""
+	if msg.Header != nil {
+		messageId = msg.Header.MessageId
+	}
+	if pool.duplicates != nil && len(messageId) > 0 && pool.duplicates.Seen(messageId, time.Now()) {
+		return utils.ReturnDuplicateAck()
+	}
+
+	// Messages are only acknowledged once every interested stream has stored them
+	stored, err := pool.store.Capture(msg)
+	if err != nil {
+		if err == store.DuplicateMessageError {
+			return utils.ReturnDuplicateAck()
+		}
+		if pool.duplicates != nil && len(messageId) > 0 {
+			pool.duplicates.Forget(messageId)
+		}
+		log.Println("Failed to store message:", err)
+		return utils.ReturnErrorAck(err)
+	}
 
-	for _, _cc := range pool.Clients {
-		go func(_cc *schemas.ClientConnection) {
-			checkAndSendToClient(msg, _cc)
-		}(_cc)
+	delivered := pool.broadcast(msg, true)
+	if msg.Publish.Guaranteed && !delivered && len(stored) == 0 && !pool.peersInterested(msg.Publish.Subject) {
+		return pool.deadLetterUnhandled(msg)
 	}
 
 	pool.msgsToPeers <- msg
 	return utils.ReturnSuccessAck()
 }
 
-func checkAndSendToClient(msg *schemas.Message, cc *schemas.ClientConnection) {
-	for _, subs := range cc.SubscribedSubjects {
-		if routing.MatchSubject(msg.Publish.Subject, subs) {
-			m := &schemas.Message{
-				Kind:   schemas.KindBounty,
-				Header: msg.Header,
-				Bounty: msg.Publish.ToBounty(),
+// capture stores a message published on another server in the local streams.
+// Every server keeps its own sequences, so the expected last sequence was only checked where the message was published.
+func (pool *TcpHandlerPool) capture(msg *schemas.Message) {
+	publish := *msg.Publish
+	publish.Headers = publish.Headers.Clone()
+	delete(publish.Headers, schemas.HeaderExpectedLastSequence)
+
+	_, err := pool.store.Capture(&schemas.Message{Kind: msg.Kind, Header: msg.Header, Publish: &publish})
+	if err != nil && err != store.DuplicateMessageError {
+		log.Println("Failed to store peer message:", err)
+	}
+}
+
+func (pool *TcpHandlerPool) handleRequest(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
+	request := msg.Request
+	if err := auth.CheckPublish(cc.User, request.Subject); err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	if err := checkSubject(request.Subject); err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
+	headers, err := pool.checkHeaders(request.Headers)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	request.Headers = timestamped(headers)
+	msg.Header = clientHeader(msg.Header, cc)
+
+	inbox := routing.NewInbox()
+
+	timeout := DefaultRequestTimeout
+	if request.Timeout > 0 {
+		timeout = time.Duration(request.Timeout) * time.Millisecond
+	}
+
+	pool.requestLock.Lock()
+	pool.requests[inbox] = &pendingRequest{
+		header: msg.Header,
+		client: cc,
+		timer: time.AfterFunc(timeout, func() {
+			if pool.takeRequest(inbox) != nil {
+				writeToClient(cc, utils.ReturnErrorAck(RequestTimeoutError))
+			}
+		}),
+	}
+	pool.requestLock.Unlock()
+
+	// Peers must know about the inbox so that replies from remote clients are routed back
+	pool.subscribePeers(inbox)
+
+	publish := &schemas.Message{
+		Kind:    schemas.KindPublish,
+		Header:  msg.Header,
+		Publish: request.ToPublish(inbox),
+	}
+	pool.broadcast(publish, true)
+	pool.msgsToPeers <- publish
+	return nil
+}
+
+// checkSubject refuses empty and wildcard subjects, and the subjects the server stores on its own, which clients may only change through their dedicated messages
+func checkSubject(subject string) error {
+	// Messages are published on a single subject, which wildcards would make ambiguous
+	if len(subject) == 0 {
+		return routing.InvalidSubjectError
+	}
+	for _, token := range strings.Split(subject, routing.SubjectDelimiter) {
+		if token == routing.SubjectSingleWildcard || token == routing.SubjectMultipleWildcards {
+			return fmt.Errorf("%w: %s contains a wildcard", routing.InvalidSubjectError, subject)
+		}
+	}
+	if strings.HasPrefix(subject, store.KvSubjectPrefix) {
+		return fmt.Errorf("%w: %s belongs to a key-value bucket, use %s instead", ReservedSubjectError, subject, schemas.KindKvPut)
+	}
+	if strings.HasPrefix(subject, store.ObjectSubjectPrefix) {
+		return fmt.Errorf("%w: %s belongs to an object store, use %s instead", ReservedSubjectError, subject, schemas.KindObjPut)
+	}
+	return nil
+}
+
+// checkHeaders puts the headers set by a client in canonical form, and validates them
+func (pool *TcpHandlerPool) checkHeaders(headers schemas.Headers) (schemas.Headers, error) {
+	if headers == nil {
+		return nil, nil
+	}
+
+	headers = headers.Canonical()
+	if err := utils.CheckHeaders(headers, pool.config.Server.MaxHeaderBytes); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// clientHeader returns a copy of the header sent by a client, without the fields only routes and leaf nodes may set.
+// The messages of leaf nodes take their name as origin, so that they are not sent back to them.
+func clientHeader(header *schemas.Header, cc *schemas.ClientConnection) *schemas.Header {
+	if header == nil && len(cc.LeafName) == 0 {
+		return nil
+	}
+
+	h := schemas.Header{}
+	if header != nil {
+		h = *header
+	}
+	h.Origin = cc.LeafName
+	h.Hops = 0
+	return &h
+}
+
+// timestamped returns a copy of the headers with the time the message is published
+func timestamped(headers schemas.Headers) schemas.Headers {
+	headers = headers.Clone()
+	if headers == nil {
+		headers = make(schemas.Headers)
+	}
+	headers.Set(schemas.HeaderTimestamp, time.Now().UTC().Format(time.RFC3339Nano))
+	return headers
+}
+
+// takeRequest removes and returns the request waiting on the inbox, if any
+func (pool *TcpHandlerPool) takeRequest(inbox string) *pendingRequest {
+	pool.requestLock.Lock()
+	pending, ok := pool.requests[inbox]
+	delete(pool.requests, inbox)
+	pool.requestLock.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	pending.timer.Stop()
+	pool.unsubscribePeers(inbox)
+	return pending
+}
+
+// deliverReply sends the first reply on an inbox back to the requester.
+// It returns false if nobody is waiting on the subject.
+func (pool *TcpHandlerPool) deliverReply(msg *schemas.Message) bool {
+	if !routing.IsInbox(msg.Publish.Subject) {
+		return false
+	}
+
+	pending := pool.takeRequest(msg.Publish.Subject)
+	if pending == nil {
+		return false
+	}
+
+	m := &schemas.Message{
+		Kind:   schemas.KindBounty,
+		Header: pending.header,
+		Bounty: msg.Publish.ToBounty(),
+	}
+	writeToClient(pending.client, m)
+	return true
+}
+
+// broadcast delivers the message to every interested client without a group,
+// and to exactly one interested member of each client group if toGroups is set. It tells whether there were any.
+// Client groups are local to a server, so messages from other servers are not delivered to them.
+func (pool *TcpHandlerPool) broadcast(msg *schemas.Message, toGroups bool) bool {
+	if pool.deliverReply(msg) {
+		return true
+	}
+
+	delivered := false
+
+	groups := make(map[string][]*schemas.ClientConnection)
+	for _, sub := range pool.subscriptions.Match(msg.Publish.Subject) {
+		_cc := sub.(*schemas.ClientConnection)
+		// Wildcard subscriptions may still cover subjects the client is not allowed to receive
+		if auth.CheckSubscribe(_cc.User, msg.Publish.Subject) != nil {
+			continue
+		}
+		// Filters are applied before picking a group member, so the message goes to a member which wants it
+		if !pool.passesFilters(_cc, msg.Publish) {
+			continue
+		}
+		// A leaf node subscribed to a subject it also exports would otherwise get its own messages back
+		if len(_cc.LeafName) > 0 && msg.Header != nil && msg.Header.Origin == _cc.LeafName {
+			continue
+		}
+		if len(_cc.ClientGroup) > 0 {
+			if toGroups {
+				groups[_cc.ClientGroup] = append(groups[_cc.ClientGroup], _cc)
+				delivered = true
 			}
-			utils.WriteToIo(cc.TcpConnection, m)
+			continue
 		}
+		delivered = true
+		go sendToClient(msg, _cc)
+	}
+
+	for group, members := range groups {
+		go sendToClient(msg, pool.pickGroupMember(group, members))
+	}
+	return delivered
+}
+
+// pickGroupMember selects the next member of the group in round-robin order
+func (pool *TcpHandlerPool) pickGroupMember(group string, members []*schemas.ClientConnection) *schemas.ClientConnection {
+	pool.groupLock.Lock()
+	defer pool.groupLock.Unlock()
+
+	cursor := pool.groupCursors[group] % len(members)
+	pool.groupCursors[group] = cursor + 1
+	return members[cursor]
+}
+
+func sendToClient(msg *schemas.Message, cc *schemas.ClientConnection) {
+	m := &schemas.Message{
+		Kind:   schemas.KindBounty,
+		Header: msg.Header,
+		Bounty: msg.Publish.ToBounty(),
+	}
+	writeToClient(cc, m)
+}
+
+// writeToClient sends the message over the client's connection type
+func writeToClient(cc *schemas.ClientConnection, msg *schemas.Message) error {
+	cc.WriteLock.Lock()
+	defer cc.WriteLock.Unlock()
+
+	switch cc.ConnectionType {
+	case schemas.ConnectionTypeWebsocket:
+		return cc.WsConnection.WriteJSON(msg)
+	case schemas.ConnectionTypeSse:
+		return writeEvent(cc.HttpWriter, msg)
+	default:
+		return utils.WriteToIo(cc.TcpConnection, msg)
 	}
 }
 
 func (pool *TcpHandlerPool) handleSubscribe(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
 	subscribe := msg.Subscribe
+	if subscribe.Replay != nil {
+		return pool.startReplay(subscribe, cc)
+	}
+	if len(subscribe.Stream) > 0 {
+		return pool.bindConsumer(subscribe, cc)
+	}
+
+	if err := auth.CheckSubscribe(cc.User, subscribe.Subject); err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+	filter, err := compileFilter(subscribe)
+	if err != nil {
+		return utils.ReturnErrorAck(err)
+	}
+
 	if len(cc.SubscribedSubjects) == 0 {
 		cc.SubscribedSubjects = make([]string, 0)
 	}
 	cc.SubscribedSubjects = append(cc.SubscribedSubjects, subscribe.Subject)
-	pool.msgsToPeers <- msg
+	pool.setFilter(cc, subscribe.Subject, filter)
+	pool.subscriptions.Insert(subscribe.Subject, cc)
+	// Members of client groups only receive the messages published on this server, which peers need not send
+	if len(cc.ClientGroup) == 0 {
+		pool.subscribePeers(subscribe.Subject)
+	}
 	return utils.ReturnSuccessAck()
 }
 
 func (pool *TcpHandlerPool) handleUnsubscribe(msg *schemas.Message, cc *schemas.ClientConnection) *schemas.Message {
 	unsubscribe := msg.Unsubscribe
+	if len(unsubscribe.Stream) > 0 && len(unsubscribe.Durable) == 0 {
+		return pool.stopReplay(unsubscribe, cc)
+	}
+	if len(unsubscribe.Stream) > 0 {
+		return pool.unbindConsumer(unsubscribe, cc)
+	}
+
+	if !utils.ContainsItem(cc.SubscribedSubjects, unsubscribe.Subject) {
+		return utils.ReturnSuccessAck()
+	}
 	cc.SubscribedSubjects = utils.RemoveItem(cc.SubscribedSubjects, unsubscribe.Subject)
-	pool.msgsToPeers <- msg
+	pool.subscriptions.Remove(unsubscribe.Subject, cc)
+	if !utils.ContainsItem(cc.SubscribedSubjects, unsubscribe.Subject) {
+		pool.removeFilter(cc, unsubscribe.Subject)
+	}
+	if len(cc.ClientGroup) == 0 {
+		pool.unsubscribePeers(unsubscribe.Subject)
+	}
 	return utils.ReturnSuccessAck()
 }
+
+// removeClient withdraws all subscriptions of a disconnected client
+func (pool *TcpHandlerPool) removeClient(cc *schemas.ClientConnection) {
+	pool.unbindConsumers(cc)
+	pool.abortUploads(cc)
+
+	for _, subject := range cc.SubscribedSubjects {
+		pool.subscriptions.Remove(subject, cc)
+		if len(cc.ClientGroup) == 0 {
+			pool.unsubscribePeers(subject)
+		}
+	}
+	cc.SubscribedSubjects = nil
+	pool.removeFilters(cc)
+
+	pool.clientLock.Lock()
+	defer pool.clientLock.Unlock()
+	for i, _cc := range pool.Clients {
+		if _cc == cc {
+			pool.Clients = append(pool.Clients[:i], pool.Clients[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package net
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/utils"
+	"log"
+	"net/http"
+)
+
+// StartWebsocket serves WebSocket clients, which speak the same protocol as TCP clients with one message per frame
+func (pool *TcpHandlerPool) StartWebsocket() error {
+	wsConfig := pool.config.Websocket
+	path := wsConfig.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+
+	upgrader := &websocket.Upgrader{
+		CheckOrigin: checkOrigin(wsConfig.AllowedOrigins),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has already replied with an HTTP error
+			return
+		}
+		go pool.handleWebsocketConnection(conn)
+	})
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", wsConfig.Address, wsConfig.Port),
+		Handler: mux,
+	}
+
+	if wsConfig.Tls == nil {
+		return server.ListenAndServe()
+	}
+
+	tlsConfig, err := utils.NewServerTlsConfig(wsConfig.Tls)
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = tlsConfig
+	return server.ListenAndServeTLS("", "")
+}
+
+func (pool *TcpHandlerPool) handleWebsocketConnection(conn *websocket.Conn) {
+	cc := &schemas.ClientConnection{
+		ConnectionType: schemas.ConnectionTypeWebsocket,
+		TcpConnection:  conn.UnderlyingConn(),
+		WsConnection:   conn,
+		MapUser:        mapsUser(pool.config.Websocket.Tls),
+	}
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			pool.removeClient(cc)
+			conn.Close()
+			return
+		}
+
+		response := pool.handleIncomingMessage(string(data), cc)
+
+		if response != nil && !(cc.SuppressAcks && response.Kind == schemas.KindAck) {
+			writeToClient(cc, response)
+		}
+	}
+}
+
+// checkOrigin allows same origin requests, and requests from the allowed origins
+func checkOrigin(allowedOrigins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 || origin == "http://"+r.Host || origin == "https://"+r.Host {
+			return true
+		}
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" || allowed == origin {
+				return true
+			}
+		}
+		log.Println("Rejected WebSocket origin:", origin)
+		return false
+	}
+}
@@ -0,0 +1,494 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	InvalidFilterError = errors.New("invalid filter")
+)
+
+// Filter is a compiled filter expression, selecting the messages of a subscription by their headers and body.
+//
+// An expression compares operands with ==, !=, <, <=, > and >=, and combines comparisons with &&, || and !,
+// grouped by parentheses. Operands are string, number, true, false and null literals,
+// header.<name> for the first value of a header, and body.<field>[.<field>...] for a field of a JSON body,
+// where fields of arrays are indexes and fields with other characters are quoted as body["a field"].
+// Missing headers and fields are null. A lone operand holds when it is true, a non-empty string or a non-zero number.
+//
+//	body.amount > 100 && header.region == "eu"
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// CompileFilter parses the expression once, to match messages against it
+func CompileFilter(expr string) (*Filter, error) {
+	p := &filterParser{expr: expr}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEnd {
+		return nil, p.unexpected(tok)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match tells whether a message with the headers and the decoded JSON body passes the filter
+func (f *Filter) Match(headers map[string][]string, body interface{}) bool {
+	return truthy(f.root.eval(headers, body))
+}
+
+type filterNode interface {
+	eval(headers map[string][]string, body interface{}) interface{}
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(map[string][]string, interface{}) interface{} {
+	return n.value
+}
+
+type headerNode struct {
+	name string
+}
+
+func (n *headerNode) eval(headers map[string][]string, _ interface{}) interface{} {
+	values := headers[n.name]
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+type bodyNode struct {
+	path []string
+}
+
+func (n *bodyNode) eval(_ map[string][]string, body interface{}) interface{} {
+	value := body
+	for _, field := range n.path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[field]
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+type notNode struct {
+	operand filterNode
+}
+
+func (n *notNode) eval(headers map[string][]string, body interface{}) interface{} {
+	return !truthy(n.operand.eval(headers, body))
+}
+
+type logicalNode struct {
+	and         bool
+	left, right filterNode
+}
+
+func (n *logicalNode) eval(headers map[string][]string, body interface{}) interface{} {
+	left := truthy(n.left.eval(headers, body))
+	if n.and != left {
+		return left
+	}
+	return truthy(n.right.eval(headers, body))
+}
+
+type compareNode struct {
+	op          string
+	left, right filterNode
+}
+
+func (n *compareNode) eval(headers map[string][]string, body interface{}) interface{} {
+	left, right := n.left.eval(headers, body), n.right.eval(headers, body)
+
+	// Headers are strings, so they compare as numbers against numbers
+	if l, ok := left.(float64); ok {
+		right = toNumber(right)
+		return compareOrdered(n.op, l, right)
+	}
+	if r, ok := right.(float64); ok {
+		left = toNumber(left)
+		if l, ok := left.(float64); ok {
+			return compareOrdered(n.op, l, r)
+		}
+		return n.op == "!="
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	}
+	l, lok := left.(string)
+	r, rok := right.(string)
+	if !lok || !rok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	default:
+		return l >= r
+	}
+}
+
+func compareOrdered(op string, l float64, right interface{}) bool {
+	r, ok := right.(float64)
+	if !ok {
+		return op == "!="
+	}
+	switch op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	default:
+		return l >= r
+	}
+}
+
+// equal compares scalars only, as objects and arrays of the body cannot be written as literals
+func equal(left, right interface{}) bool {
+	switch left.(type) {
+	case nil, bool, string:
+		return left == right
+	}
+	return false
+}
+
+func toNumber(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return len(v) > 0
+	case float64:
+		return v != 0
+	default:
+		return true
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+type filterParser struct {
+	expr   string
+	tokens []token
+	next   int
+}
+
+var filterOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", "."}
+
+// lex splits the expression into tokens
+func (p *filterParser) lex() error {
+	for i := 0; i < len(p.expr); {
+		c := p.expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(p.expr) && p.expr[end] != c {
+				if p.expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(p.expr) {
+				return fmt.Errorf("%w: unterminated string at %d", InvalidFilterError, i)
+			}
+			text := p.expr[i : end+1]
+			if c == '\'' {
+				text = `"` + strings.ReplaceAll(text[1:len(text)-1], `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(text)
+			if err != nil {
+				return fmt.Errorf("%w: invalid string at %d", InvalidFilterError, i)
+			}
+			p.tokens = append(p.tokens, token{kind: tokenString, text: p.expr[i : end+1], value: value, pos: i})
+			i = end + 1
+		case isDigit(c) || (c == '-' && i+1 < len(p.expr) && isDigit(p.expr[i+1])):
+			end := i + 1
+			for end < len(p.expr) && (isDigit(p.expr[end]) || (p.expr[end] == '.' && end+1 < len(p.expr) && isDigit(p.expr[end+1])) || p.expr[end] == 'e' || p.expr[end] == 'E') {
+				end++
+			}
+			value, err := strconv.ParseFloat(p.expr[i:end], 64)
+			if err != nil {
+				return fmt.Errorf("%w: invalid number %q at %d", InvalidFilterError, p.expr[i:end], i)
+			}
+			p.tokens = append(p.tokens, token{kind: tokenNumber, text: p.expr[i:end], value: value, pos: i})
+			i = end
+		case isIdentStart(rune(c)):
+			end := i + 1
+			for end < len(p.expr) && isIdentPart(rune(p.expr[end])) {
+				end++
+			}
+			p.tokens = append(p.tokens, token{kind: tokenIdent, text: p.expr[i:end], pos: i})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range filterOperators {
+				if strings.HasPrefix(p.expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if len(op) == 0 {
+				return fmt.Errorf("%w: unexpected %q at %d", InvalidFilterError, c, i)
+			}
+			p.tokens = append(p.tokens, token{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tokenEnd, pos: len(p.expr)})
+	return nil
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *filterParser) take() token {
+	tok := p.tokens[p.next]
+	if tok.kind != tokenEnd {
+		p.next++
+	}
+	return tok
+}
+
+// accept takes the next token if it is the operator
+func (p *filterParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokenOperator && tok.text == op {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) unexpected(tok token) error {
+	if tok.kind == tokenEnd {
+		return fmt.Errorf("%w: unexpected end of expression", InvalidFilterError)
+	}
+	return fmt.Errorf("%w: unexpected %q at %d", InvalidFilterError, tok.text, tok.pos)
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.kind != tokenOperator {
+		return left, nil
+	}
+	switch tok.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &compareNode{op: tok.text, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseOperand() (filterNode, error) {
+	tok := p.take()
+	switch tok.kind {
+	case tokenString, tokenNumber:
+		return &literalNode{value: tok.value}, nil
+	case tokenOperator:
+		if tok.text != "(" {
+			return nil, p.unexpected(tok)
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.unexpected(p.peek())
+		}
+		return inner, nil
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		case "header":
+			path, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			if len(path) != 1 {
+				return nil, fmt.Errorf("%w: header at %d must name a single header", InvalidFilterError, tok.pos)
+			}
+			return &headerNode{name: textproto.CanonicalMIMEHeaderKey(path[0])}, nil
+		case "body":
+			path, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			return &bodyNode{path: path}, nil
+		}
+	}
+	return nil, p.unexpected(tok)
+}
+
+// parsePath reads the .field and ["field"] selectors following header or body
+func (p *filterParser) parsePath() ([]string, error) {
+	path := make([]string, 0)
+	for {
+		switch {
+		case p.accept("."):
+			tok := p.take()
+			if tok.kind != tokenIdent && !(tok.kind == tokenNumber && isIndex(tok.text)) {
+				return nil, p.unexpected(tok)
+			}
+			path = append(path, tok.text)
+		case p.accept("["):
+			tok := p.take()
+			if tok.kind == tokenString {
+				path = append(path, tok.value.(string))
+			} else if tok.kind == tokenNumber && isIndex(tok.text) {
+				path = append(path, tok.text)
+			} else {
+				return nil, p.unexpected(tok)
+			}
+			if !p.accept("]") {
+				return nil, p.unexpected(p.peek())
+			}
+		default:
+			return path, nil
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIndex(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if !isDigit(text[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,70 @@
+package routing
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	headers := map[string][]string{
+		"Region":   {"eu", "us"},
+		"Priority": {"7"},
+	}
+	var body interface{}
+	if err := json.Unmarshal([]byte(`{"amount": 250, "currency": "EUR", "paid": true, "items": [{"sku": "a-1"}], "the note": "gift"}`), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`body.amount > 100 && header.region == "eu"`, true},
+		{`body.amount > 300 || header.region == "us"`, false},
+		{`body.amount >= 250 && body.amount <= 250`, true},
+		{`body.currency != 'USD'`, true},
+		{`body.paid`, true},
+		{`!body.paid`, false},
+		{`body.missing == null`, true},
+		{`body.missing`, false},
+		{`body.items.0.sku == "a-1"`, true},
+		{`body.items[0]["sku"] == "a-1"`, true},
+		{`body["the note"] == "gift"`, true},
+		{`header.priority > 5`, true},
+		{`header.Priority == 7`, true},
+		{`header.region > "a"`, true},
+		{`!(body.amount < 100 || body.currency == "USD")`, true},
+		{`body.currency > 10`, false},
+		{`body.items == null`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := CompileFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("CompileFilter() error = %v", err)
+			}
+			if got := f.Match(headers, body); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`body.amount >`,
+		`body.amount > 100 &&`,
+		`(body.amount > 100`,
+		`amount > 100`,
+		`header == "eu"`,
+		`header.a.b == "eu"`,
+		`body.name == "unterminated`,
+		`body.amount = 100`,
+	} {
+		if _, err := CompileFilter(expr); !errors.Is(err, InvalidFilterError) {
+			t.Errorf("CompileFilter(%q) error = %v, want %v", expr, err, InvalidFilterError)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package routing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	InboxPrefix = "_INBOX"
+)
+
+// NewInbox mints a unique subject to which replies for a single request are sent
+func NewInbox() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return InboxPrefix + SubjectDelimiter + hex.EncodeToString(b)
+}
+
+func IsInbox(subject string) bool {
+	return strings.HasPrefix(subject, InboxPrefix+SubjectDelimiter)
+}
@@ -20,25 +20,26 @@ func MatchSubject(subject string, subscription string) bool {
 	subjectChunks := strings.Split(subject, SubjectDelimiter)
 	subscriptionChunks := strings.Split(subscription, SubjectDelimiter)
 
-	for index, c := range subjectChunks {
-		if subscriptionChunks[index] == ">" {
-			return true
+	for index, c := range subscriptionChunks {
+		// The multiple wildcard matches one or more remaining tokens
+		if c == SubjectMultipleWildcards {
+			return len(subjectChunks) > index
 		}
 
-		if len(subscriptionChunks) < len(subjectChunks) {
+		if index >= len(subjectChunks) {
 			return false
 		}
 
-		if subscriptionChunks[index] == "*" {
+		if c == SubjectSingleWildcard {
 			continue
 		}
 
-		if c != subscriptionChunks[index] {
+		if c != subjectChunks[index] {
 			return false
 		}
 	}
 
-	return true
+	return len(subjectChunks) == len(subscriptionChunks)
 }
 
 func ValidateSubject(subject string) error {
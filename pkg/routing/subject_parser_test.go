@@ -97,6 +97,24 @@ var matchTests = []struct {
 		name:         "Multiple Token match",
 		subject:      "time.us.atlanta",
 		subscription: "time.>",
+		want:         true,
+	},
+	{
+		name:         "Multiple Token needs at least one token",
+		subject:      "time",
+		subscription: "time.>",
+		want:         false,
+	},
+	{
+		name:         "Subscription longer than subject",
+		subject:      "time",
+		subscription: "time.us",
+		want:         false,
+	},
+	{
+		name:         "Subscription shorter than subject",
+		subject:      "time.us",
+		subscription: "time",
 		want:         false,
 	},
 }
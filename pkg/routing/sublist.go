@@ -0,0 +1,178 @@
+package routing
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	maxCachedSubjects = 1024
+)
+
+// Sublist is a trie of subscriptions keyed on subject tokens.
+// It finds the subscribers interested in a subject without scanning every subscription,
+// and caches match results until a subscription affecting the subject changes.
+type Sublist struct {
+	lock  sync.RWMutex
+	root  *level
+	cache map[string][]interface{}
+}
+
+type level struct {
+	nodes map[string]*node
+}
+
+type node struct {
+	next   *level
+	subs   []interface{}       // subs preserves insertion order, so that results are stable
+	counts map[interface{}]int // counts tracks how many times each sub subscribed to the node
+}
+
+func NewSublist() *Sublist {
+	return &Sublist{
+		root:  newLevel(),
+		cache: make(map[string][]interface{}),
+	}
+}
+
+func newLevel() *level {
+	return &level{nodes: make(map[string]*node)}
+}
+
+// Insert registers sub as interested in the subscription, which may contain wildcards
+func (s *Sublist) Insert(subscription string, sub interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	l := s.root
+	var n *node
+	for _, token := range strings.Split(subscription, SubjectDelimiter) {
+		n = l.nodes[token]
+		if n == nil {
+			n = &node{counts: make(map[interface{}]int)}
+			l.nodes[token] = n
+		}
+		if n.next == nil {
+			n.next = newLevel()
+		}
+		l = n.next
+	}
+
+	if n.counts[sub] == 0 {
+		n.subs = append(n.subs, sub)
+	}
+	n.counts[sub]++
+	s.invalidate(subscription)
+}
+
+// Remove withdraws one interest of sub in the subscription
+func (s *Sublist) Remove(subscription string, sub interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if removeFromLevel(s.root, strings.Split(subscription, SubjectDelimiter), sub) {
+		s.invalidate(subscription)
+	}
+}
+
+// Match returns the distinct subs interested in the subject
+func (s *Sublist) Match(subject string) []interface{} {
+	s.lock.RLock()
+	cached, ok := s.cache[subject]
+	s.lock.RUnlock()
+	if ok {
+		return cached
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	results := make([]interface{}, 0)
+	seen := make(map[interface{}]bool)
+	matchLevel(s.root, strings.Split(subject, SubjectDelimiter), &results, seen)
+
+	if len(s.cache) >= maxCachedSubjects {
+		for key := range s.cache {
+			delete(s.cache, key)
+			break
+		}
+	}
+	s.cache[subject] = results
+	return results
+}
+
+// invalidate drops every cached result the subscription could have changed
+func (s *Sublist) invalidate(subscription string) {
+	for subject := range s.cache {
+		if MatchSubject(subject, subscription) {
+			delete(s.cache, subject)
+		}
+	}
+}
+
+func matchLevel(l *level, tokens []string, results *[]interface{}, seen map[interface{}]bool) {
+	if n := l.nodes[SubjectMultipleWildcards]; n != nil {
+		collect(n, results, seen)
+	}
+
+	for _, key := range []string{tokens[0], SubjectSingleWildcard} {
+		n := l.nodes[key]
+		if n == nil {
+			continue
+		}
+		if len(tokens) == 1 {
+			collect(n, results, seen)
+		} else {
+			matchLevel(n.next, tokens[1:], results, seen)
+		}
+	}
+}
+
+func collect(n *node, results *[]interface{}, seen map[interface{}]bool) {
+	for _, sub := range n.subs {
+		if !seen[sub] {
+			seen[sub] = true
+			*results = append(*results, sub)
+		}
+	}
+}
+
+// removeFromLevel removes sub and prunes the nodes left empty behind it
+func removeFromLevel(l *level, tokens []string, sub interface{}) bool {
+	n := l.nodes[tokens[0]]
+	if n == nil {
+		return false
+	}
+
+	removed := false
+	if len(tokens) == 1 {
+		removed = n.removeSub(sub)
+	} else {
+		removed = removeFromLevel(n.next, tokens[1:], sub)
+	}
+
+	if len(n.subs) == 0 && len(n.next.nodes) == 0 {
+		delete(l.nodes, tokens[0])
+	}
+	return removed
+}
+
+func (n *node) removeSub(sub interface{}) bool {
+	count, ok := n.counts[sub]
+	if !ok {
+		return false
+	}
+	if count > 1 {
+		n.counts[sub] = count - 1
+		return true
+	}
+
+	delete(n.counts, sub)
+	for i, s := range n.subs {
+		if s == sub {
+			n.subs = append(n.subs[:i], n.subs[i+1:]...)
+			break
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package routing
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSublistMatch(t *testing.T) {
+	sl := NewSublist()
+	sl.Insert("time.us.atlanta", "exact")
+	sl.Insert("time.*.atlanta", "single")
+	sl.Insert("time.>", "multiple")
+	sl.Insert(">", "all")
+	sl.Insert("weather", "other")
+
+	tests := []struct {
+		name    string
+		subject string
+		want    []interface{}
+	}{
+		{
+			name:    "All wildcards",
+			subject: "time.us.atlanta",
+			want:    []interface{}{"all", "multiple", "exact", "single"},
+		},
+		{
+			name:    "Multiple wildcard only",
+			subject: "time.us",
+			want:    []interface{}{"all", "multiple"},
+		},
+		{
+			name:    "Multiple wildcard needs a token",
+			subject: "time",
+			want:    []interface{}{"all"},
+		},
+		{
+			name:    "Exact",
+			subject: "weather",
+			want:    []interface{}{"all", "other"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sl.Match(tt.subject); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSublistRemove(t *testing.T) {
+	sl := NewSublist()
+	sl.Insert("time.*", "a")
+	sl.Insert("time.*", "a")
+	sl.Insert("time.*", "b")
+
+	if got := sl.Match("time.us"); len(got) != 2 {
+		t.Fatalf("Match() = %v, want 2 subs", got)
+	}
+
+	// a subscribed twice, so it stays interested after the first removal
+	sl.Remove("time.*", "a")
+	sl.Remove("time.*", "b")
+	if got := sl.Match("time.us"); !reflect.DeepEqual(got, []interface{}{"a"}) {
+		t.Fatalf("Match() = %v, want [a]", got)
+	}
+
+	sl.Remove("time.*", "a")
+	if got := sl.Match("time.us"); len(got) != 0 {
+		t.Fatalf("Match() = %v, want no subs", got)
+	}
+	if len(sl.root.nodes) != 0 {
+		t.Errorf("Remove() left %d nodes behind", len(sl.root.nodes))
+	}
+}
+
+var sublistMatches []interface{}
+
+func BenchmarkSublistMatch(b *testing.B) {
+	sl := NewSublist()
+	for i := 0; i < 10000; i++ {
+		sl.Insert(fmt.Sprintf("time.%d.*", i), i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sublistMatches = sl.Match(fmt.Sprintf("time.%d.atlanta", i%10000))
+	}
+}
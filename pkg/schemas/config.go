@@ -1,23 +1,174 @@
 package schemas
 
 type Config struct {
-	Server  *Server  `json:"server"`
-	Cluster *Cluster `json:"cluster"`
+	Server        *Server        `json:"server"`
+	Cluster       *Cluster       `json:"cluster"`
+	Authorization *Authorization `json:"authorization,omitempty"`
+	Websocket     *Websocket     `json:"websocket,omitempty"`
+	Http          *Http          `json:"http,omitempty"`
+	Storage       *Storage       `json:"storage,omitempty"`
+	Leaf          *Leaf          `json:"leaf,omitempty"`
 }
 
 type Server struct {
-	Name    string `json:"name"`
+	Name            string `json:"name"`
+	Address         string `json:"address"`
+	Port            int    `json:"port"`
+	Tls             *Tls   `json:"tls,omitempty"`
+	DuplicateWindow int    `json:"duplicate_window_ms,omitempty"` // DuplicateWindow drops publishes repeating a header message id seen within this many milliseconds
+
+	// DeadLetterSubject receives the guaranteed publishes nothing was interested in
+	DeadLetterSubject string `json:"dead_letter_subject,omitempty"`
+
+	MaxHeaderBytes int `json:"max_header_bytes,omitempty"` // MaxHeaderBytes limits the total size of the headers of a message, 8KB by default
+}
+
+// Websocket configures the listener for WebSocket clients, such as browsers
+type Websocket struct {
+	Address        string   `json:"address"`
+	Port           int      `json:"port"`
+	Path           string   `json:"path,omitempty"`            // Path is the HTTP path upgraded to WebSocket, defaults to /
+	AllowedOrigins []string `json:"allowed_origins,omitempty"` // AllowedOrigins lists the origins browsers may connect from. Only same origin is allowed if empty, and * allows all.
+	Tls            *Tls     `json:"tls,omitempty"`
+}
+
+// Http configures the gateway where clients publish with POST /publish/{subject}
+// and subscribe to Server-Sent Events with GET /subscribe/{subject}
+type Http struct {
 	Address string `json:"address"`
 	Port    int    `json:"port"`
+	Tls     *Tls   `json:"tls,omitempty"`
+}
+
+// Storage configures the persistent streams and the directory they are stored in
+type Storage struct {
+	Dir     string     `json:"dir"`
+	Streams []*Stream  `json:"streams"`
+	Buckets []*Bucket  `json:"buckets,omitempty"`
+	Objects []*Objects `json:"objects,omitempty"`
+}
+
+// Bucket is a key-value store kept in a stream, which only holds the latest revisions of each key
+type Bucket struct {
+	Name     string `json:"name"`
+	History  int    `json:"history,omitempty"`   // History is the number of revisions kept for each key, 1 by default
+	MaxBytes int64  `json:"max_bytes,omitempty"` // MaxBytes deletes the oldest revisions beyond this size
+	Sync     bool   `json:"sync,omitempty"`
+}
+
+// Objects is a store of large objects, kept in a stream as chunks followed by their info
+type Objects struct {
+	Name       string `json:"name"`
+	ChunkBytes int    `json:"chunk_bytes,omitempty"` // ChunkBytes is the size objects are split at, 128KB by default
+	MaxBytes   int64  `json:"max_bytes,omitempty"`   // MaxBytes is refused, since deleting the oldest chunks would corrupt objects
+	Sync       bool   `json:"sync,omitempty"`
+}
+
+// Stream captures every publish to its subjects into an on-disk log
+type Stream struct {
+	Name            string      `json:"name"`
+	Subjects        []string    `json:"subjects"`                      // Subjects may contain wildcards
+	MaxSegmentBytes int64       `json:"max_segment_bytes,omitempty"`   // MaxSegmentBytes is the size at which a new log segment is started
+	Sync            bool        `json:"sync,omitempty"`                // Sync flushes every message to disk before acknowledging it
+	DuplicateWindow int         `json:"duplicate_window_ms,omitempty"` // DuplicateWindow refuses to store a header message id stored within this many milliseconds
+	Consumers       []*Consumer `json:"consumers,omitempty"`
+
+	// Retention is the policy deciding when messages are deleted, on top of the limits below
+	Retention             string `json:"retention,omitempty"`
+	MaxMessages           int    `json:"max_messages,omitempty"`             // MaxMessages deletes the oldest messages beyond this count
+	MaxBytes              int64  `json:"max_bytes,omitempty"`                // MaxBytes deletes the oldest messages beyond this size
+	MaxAge                int    `json:"max_age_ms,omitempty"`               // MaxAge deletes messages older than this many milliseconds
+	MaxMessagesPerSubject int    `json:"max_messages_per_subject,omitempty"` // MaxMessagesPerSubject deletes the oldest messages of a subject beyond this count
+}
+
+const (
+	RetentionLimits    = "limits"     // RetentionLimits keeps messages until a limit is reached. This is the default.
+	RetentionInterest  = "interest"   // RetentionInterest deletes messages once every consumer interested in them has acknowledged them
+	RetentionWorkQueue = "work_queue" // RetentionWorkQueue deletes messages once any consumer has acknowledged them
+)
+
+// Consumer is a durable cursor over a stream, to which clients bind by subscribing with its name.
+// Every delivered message must be acknowledged, or else it is delivered again once AckWait has passed.
+type Consumer struct {
+	Name          string `json:"name"`
+	FilterSubject string `json:"filter_subject,omitempty"`  // FilterSubject restricts the messages delivered to those matching it
+	AckWait       int    `json:"ack_wait_ms,omitempty"`     // AckWait is the time in milliseconds to wait for an ack before redelivering
+	MaxDeliveries int    `json:"max_deliveries,omitempty"`  // MaxDeliveries is the number of times a message is delivered before giving up. Unlimited if 0.
+	MaxAckPending int    `json:"max_ack_pending,omitempty"` // MaxAckPending is the number of messages delivered but not yet acknowledged at any time
+
+	// DeadLetterSubject receives the messages given up on after MaxDeliveries.
+	// Dead-lettering is final, so the stream's retention then treats the message as acknowledged.
+	DeadLetterSubject string `json:"dead_letter_subject,omitempty"`
+}
+
+// Tls configures encryption of a listener
+type Tls struct {
+	Cert         string `json:"cert"`                    // Cert is the path to the PEM encoded certificate
+	Key          string `json:"key"`                     // Key is the path to the PEM encoded private key
+	Ca           string `json:"ca,omitempty"`            // Ca is the path to the PEM encoded CA used to verify the remote side
+	VerifyClient bool   `json:"verify_client,omitempty"` // VerifyClient requires clients to present a certificate signed by Ca. Routes must also be named by its common name or SANs.
+	MapUser      bool   `json:"map_user,omitempty"`      // MapUser authenticates clients as the user named by their certificate's subject or SAN, which must be configured
 }
 
 type Cluster struct {
 	Address string   `json:"address"`
 	Port    int      `json:"port"`
 	Routes  []*Route `json:"routes"`
+	Tls     *Tls     `json:"tls,omitempty"`    // Tls encrypts the route port, and is also used when dialing routes
+	Secret  string   `json:"secret,omitempty"` // Secret must be presented by peers in their PeerConnect packet
+
+	// Advertise is the address other members are told to dial this server on, when it differs from Address and Port.
+	// A server only needs a route to one member of the cluster, and learns of the others through gossip.
+	Advertise string `json:"advertise,omitempty"`
+
+	ReconnectWait    int `json:"reconnect_wait_ms,omitempty"`     // ReconnectWait is the first wait in milliseconds before redialing a route, doubled after each failure
+	MaxReconnectWait int `json:"max_reconnect_wait_ms,omitempty"` // MaxReconnectWait caps the wait in milliseconds between redials of a route
+}
+
+// Leaf makes this server a leaf node of a hub, such as an edge site with a flaky uplink.
+// The leaf connects to the client port of a hub server as a client, authenticated like any other,
+// and keeps serving its local clients while the connection is down.
+// Only the exported and imported subjects cross the connection.
+type Leaf struct {
+	Url      string   `json:"url"` // Url is the address of the client port of the hub server
+	User     string   `json:"user,omitempty"`
+	Password string   `json:"password,omitempty"`
+	Token    string   `json:"token,omitempty"`
+	Tls      *Tls     `json:"tls,omitempty"`
+	Exports  []string `json:"exports,omitempty"` // Exports are the subjects published on this server which are sent to the hub
+	Imports  []string `json:"imports,omitempty"` // Imports are the subjects published on the hub which are delivered to local clients
+
+	ReconnectWait    int `json:"reconnect_wait_ms,omitempty"`
+	MaxReconnectWait int `json:"max_reconnect_wait_ms,omitempty"`
 }
 
 type Route struct {
 	Name string `json:"name"`
 	Url  string `json:"url"`
 }
+
+// Authorization lists the credentials accepted from clients.
+// Clients may authenticate with either a configured user or the shared token.
+type Authorization struct {
+	Token string  `json:"token"`
+	Users []*User `json:"users"`
+}
+
+type User struct {
+	User        string       `json:"user"`
+	Password    string       `json:"password"`              // Password is the bcrypt hash of the user's password
+	Permissions *Permissions `json:"permissions,omitempty"` // Permissions restricts the subjects the user can use. Everything is allowed if empty.
+}
+
+type Permissions struct {
+	Publish   *SubjectPermission `json:"publish,omitempty"`
+	Subscribe *SubjectPermission `json:"subscribe,omitempty"`
+	Leaf      bool               `json:"leaf,omitempty"` // Leaf allows the user to connect leaf nodes
+}
+
+// SubjectPermission lists subject patterns, which may contain wildcards.
+// If Allow is not empty, only matching subjects are allowed. Deny always takes precedence over Allow.
+type SubjectPermission struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
@@ -1,5 +1,78 @@
 package schemas
 
+import "net/textproto"
+
+// Well-known headers. Those prefixed with Tfes- are reserved, and may only be set by clients if listed here.
+const (
+	HeaderContentType          = "Content-Type"
+	HeaderTimestamp            = "Tfes-Timestamp"              // HeaderTimestamp is set by the server to the time the message was published
+	HeaderTraceParent          = "Traceparent"                 // HeaderTraceParent is the W3C trace context of the message
+	HeaderTraceState           = "Tracestate"                  // HeaderTraceState is the vendor specific part of the W3C trace context
+	HeaderExpectedLastSequence = "Tfes-Expected-Last-Sequence" // HeaderExpectedLastSequence refuses to store the message unless it is the last sequence stored for the subject
+	ReservedHeaderPrefix       = "Tfes-"
+)
+
+// Headers are the arbitrary metadata of a message, keyed case insensitively
+type Headers map[string][]string
+
+// Get returns the first value of the header, or an empty string if it is not set
+func (h Headers) Get(key string) string {
+	values := h[textproto.CanonicalMIMEHeaderKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Set replaces the values of the header
+func (h Headers) Set(key string, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(key)] = []string{value}
+}
+
+// Canonical returns the headers with every key in canonical form, merging the values of keys differing by case
+func (h Headers) Canonical() Headers {
+	canonical := make(Headers, len(h))
+	for key, values := range h {
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		canonical[key] = append(canonical[key], values...)
+	}
+	return canonical
+}
+
+// Clone returns a copy of the headers, which may be nil
+func (h Headers) Clone() Headers {
+	if h == nil {
+		return nil
+	}
+	clone := make(Headers, len(h))
+	for key, values := range h {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+const (
+	KvOperationPut    = "put"
+	KvOperationDelete = "delete"
+
+	DeadLetterMaxDeliveries = "max_deliveries" // DeadLetterMaxDeliveries is the reason of messages a durable consumer gave up on
+	DeadLetterNoSubscribers = "no_subscribers" // DeadLetterNoSubscribers is the reason of guaranteed publishes nothing was interested in
+)
+
 type Header struct {
-	MessageId string `json:"message_id"`
+	MessageId   string      `json:"message_id"`
+	KvOperation string      `json:"kv_op,omitempty"`       // KvOperation marks the messages of a key-value bucket which delete a key
+	DeadLetter  *DeadLetter `json:"dead_letter,omitempty"` // DeadLetter is set on messages routed to a dead-letter subject
+	Origin      string      `json:"origin,omitempty"`      // Origin is the name of the server the message was published on, set once it is forwarded to a route
+	Hops        int         `json:"hops,omitempty"`        // Hops counts the routes the message has crossed
+}
+
+// DeadLetter describes why a message was routed to a dead-letter subject
+type DeadLetter struct {
+	Reason     string `json:"reason"`
+	Subject    string `json:"subject"` // Subject is the subject the message was originally published to
+	Deliveries int    `json:"deliveries"`
+	Stream     string `json:"stream,omitempty"`
+	Durable    string `json:"durable,omitempty"`
+	Sequence   uint64 `json:"seq,omitempty"`
 }
@@ -0,0 +1,21 @@
+package schemas
+
+import "time"
+
+// KvRequest is sent by client to server to operate on the keys of a bucket
+type KvRequest struct {
+	Bucket           string      `json:"bucket"`
+	Key              string      `json:"key"`                         // Key is made of subject tokens, and may contain wildcards when watching
+	Value            interface{} `json:"value,omitempty"`             // Value is the custom data stored by a put
+	ExpectedRevision *uint64     `json:"expected_revision,omitempty"` // ExpectedRevision fails a put or delete unless it is the latest revision of the key, 0 meaning the key must not exist
+}
+
+// KvEntry is a revision of a key, sent by server to client
+type KvEntry struct {
+	Bucket    string      `json:"bucket"`
+	Key       string      `json:"key"`
+	Value     interface{} `json:"value,omitempty"`
+	Revision  uint64      `json:"revision"`
+	Timestamp time.Time   `json:"ts"`
+	Operation string      `json:"op"` // Operation is either KvOperationPut or KvOperationDelete
+}
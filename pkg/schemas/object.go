@@ -0,0 +1,35 @@
+package schemas
+
+import "time"
+
+// ObjectRequest is sent by client to server to operate on the objects of a store.
+// An object is put with a series of requests carrying its data, the last one of which completes it.
+type ObjectRequest struct {
+	Store string `json:"store"`
+	Name  string `json:"name"`
+	Data  []byte `json:"data,omitempty"` // Data is the next part of the object being put, encoded in base64
+	Last  bool   `json:"last,omitempty"` // Last completes the object being put
+}
+
+// ObjectInfo describes an object, and is stored once all of its chunks have been
+type ObjectInfo struct {
+	Store     string    `json:"store"`
+	Name      string    `json:"name"`
+	Id        string    `json:"id"` // Id identifies the chunks of this version of the object
+	Size      int64     `json:"size"`
+	Chunks    int       `json:"chunks"`
+	Digest    string    `json:"digest"` // Digest is the hex encoded SHA-256 of the object
+	Timestamp time.Time `json:"ts"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+// ObjectChunk is a part of an object sent by server to client.
+// The last chunk of an object carries its info, so that the reader can check the digest.
+type ObjectChunk struct {
+	Store string      `json:"store"`
+	Name  string      `json:"name"`
+	Index int         `json:"index"`
+	Data  []byte      `json:"data,omitempty"`
+	Last  bool        `json:"last,omitempty"`
+	Info  *ObjectInfo `json:"info,omitempty"`
+}
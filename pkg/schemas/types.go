@@ -1,6 +1,12 @@
 package schemas
 
-import "net"
+import (
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
 
 const (
 	KindConnect     = "schema.tfes.client.v1.connect"
@@ -9,22 +15,52 @@ const (
 	KindSubscribe   = "schema.tfes.client.v1.subscribe"
 	KindUnsubscribe = "schema.tfes.client.v1.unsubscribe"
 	KindBounty      = "schema.tfes.client.v1.bounty"
+	KindRequest     = "schema.tfes.client.v1.request"
+	KindMessageAck  = "schema.tfes.client.v1.message_ack"
+	KindKvPut       = "schema.tfes.client.v1.kv_put"
+	KindKvGet       = "schema.tfes.client.v1.kv_get"
+	KindKvDelete    = "schema.tfes.client.v1.kv_delete"
+	KindKvHistory   = "schema.tfes.client.v1.kv_history"
+	KindKvWatch     = "schema.tfes.client.v1.kv_watch"
+	KindKvUnwatch   = "schema.tfes.client.v1.kv_unwatch"
+	KindKvEntries   = "schema.tfes.client.v1.kv_entries"
+	KindObjPut      = "schema.tfes.client.v1.obj_put"
+	KindObjGet      = "schema.tfes.client.v1.obj_get"
+	KindObjDelete   = "schema.tfes.client.v1.obj_delete"
+	KindObjList     = "schema.tfes.client.v1.obj_list"
+	KindObjWatch    = "schema.tfes.client.v1.obj_watch"
+	KindObjUnwatch  = "schema.tfes.client.v1.obj_unwatch"
+	KindObjChunk    = "schema.tfes.client.v1.obj_chunk"
+	KindObjInfos    = "schema.tfes.client.v1.obj_infos"
+	KindCancel      = "schema.tfes.client.v1.cancel"
 
 	KindPeerConnect   = "schema.tfes.peer.v1.connect"
 	KindPeerNotifySub = "schema.tfes.peer.v1.subscribe"
 	KindPeerNotifyPub = "schema.tfes.peer.v1.publish"
+	KindPeerInterest  = "schema.tfes.peer.v1.interest"
+	KindPeerGossip    = "schema.tfes.peer.v1.gossip"
 )
 
 type Message struct {
-	Kind        string       `json:"kind"`
-	Header      *Header      `json:"header,omitempty"`
-	Publish     *Publish     `json:"publish,omitempty"`
-	Subscribe   *Subscribe   `json:"subscribe,omitempty"`
-	Unsubscribe *Unsubscribe `json:"unsubscribe,omitempty"`
-	Connect     *Connect     `json:"connect,omitempty"`
-	Ack         *Ack         `json:"ack,omitempty"`
-	Bounty      *Bounty      `json:"bounty,omitempty"`
-	PeerConnect *PeerConnect `json:"peer_connect,omitempty"`
+	Kind         string         `json:"kind"`
+	Header       *Header        `json:"header,omitempty"`
+	Publish      *Publish       `json:"publish,omitempty"`
+	Subscribe    *Subscribe     `json:"subscribe,omitempty"`
+	Unsubscribe  *Unsubscribe   `json:"unsubscribe,omitempty"`
+	Request      *Request       `json:"request,omitempty"`
+	MessageAck   *MessageAck    `json:"message_ack,omitempty"`
+	Connect      *Connect       `json:"connect,omitempty"`
+	Ack          *Ack           `json:"ack,omitempty"`
+	Bounty       *Bounty        `json:"bounty,omitempty"`
+	Kv           *KvRequest     `json:"kv,omitempty"`
+	KvEntries    []*KvEntry     `json:"kv_entries,omitempty"`
+	Object       *ObjectRequest `json:"object,omitempty"`
+	ObjectChunk  *ObjectChunk   `json:"object_chunk,omitempty"`
+	Objects      []*ObjectInfo  `json:"objects,omitempty"`
+	Cancel       *Cancel        `json:"cancel,omitempty"`
+	PeerConnect  *PeerConnect   `json:"peer_connect,omitempty"`
+	PeerInterest *PeerInterest  `json:"peer_interest,omitempty"`
+	PeerGossip   *PeerGossip    `json:"peer_gossip,omitempty"`
 }
 
 type Connect struct {
@@ -34,24 +70,71 @@ type Connect struct {
 	SuppressAcks bool   `json:"suppress_acks"`
 	ClientID     string `json:"client_id"`
 	ClientGroup  string `json:"client_group"`
+	Leaf         bool   `json:"leaf,omitempty"` // Leaf is set by leaf nodes connecting to their hub, ClientID being their server name
 }
 
 type PeerConnect struct {
 	PeerName      string `json:"peer_name"`
 	AdvertiseAddr string `json:"advertise_addr"`
+	Secret        string `json:"secret,omitempty"`
+}
+
+// PeerInterest is the full set of subjects a server's clients are subscribed to.
+// It is sent once a route is established, and replaces whatever the receiver knew of the sender's interest.
+type PeerInterest struct {
+	Subjects []string `json:"subjects"`
+}
+
+// PeerGossip tells a peer about the other members of the cluster, so that every member connects to every other one.
+// A newly connected peer is sent all the members the sender is connected to, and the other members are told it joined.
+type PeerGossip struct {
+	Joined []*Member `json:"joined,omitempty"`
+	Left   []*Member `json:"left,omitempty"`
+}
+
+type Member struct {
+	Name          string `json:"name"`
+	AdvertiseAddr string `json:"advertise_addr"`
 }
 
 // Ack is the acknowledgement sent by server to client
 type Ack struct {
 	Ok          bool   `json:"ok"`
 	Description string `json:"message,omitempty"`
+	Duplicate   bool   `json:"duplicate,omitempty"` // Duplicate is set when a publish was dropped as a duplicate of an earlier one
+	Sequence    uint64 `json:"seq,omitempty"`       // Sequence is the revision a key-value put or delete was stored at
+	Id          string `json:"id,omitempty"`        // Id identifies a scheduled publish, to cancel it
 }
 
 // Publish is sent by client to server
 type Publish struct {
-	Subject string      `json:"subject"`  // The Subject to which the message must be delivered
-	ReplyTo string      `json:"reply_to"` // ReplyTo is the subject to which the reply of the message needs to be sent
-	Body    interface{} `json:"body"`     // Body is the custom data that the client wants to send over
+	Subject   string      `json:"subject"`              // The Subject to which the message must be delivered
+	ReplyTo   string      `json:"reply_to"`             // ReplyTo is the subject to which the reply of the message needs to be sent
+	Body      interface{} `json:"body"`                 // Body is the custom data that the client wants to send over
+	Headers   Headers     `json:"headers,omitempty"`    // Headers is the metadata of the message, delivered along with it
+	DeliverAt *time.Time  `json:"deliver_at,omitempty"` // DeliverAt holds the message until the given time
+	Delay     int         `json:"delay_ms,omitempty"`   // Delay holds the message for this many milliseconds
+
+	// Guaranteed routes the message to the server's dead-letter subject if no subscriber, peer or stream is interested in it
+	Guaranteed bool `json:"guaranteed,omitempty"`
+}
+
+// IsScheduled tells if the publish is to be held by the server until it is due
+func (publish *Publish) IsScheduled() bool {
+	return publish.DeliverAt != nil || publish.Delay > 0
+}
+
+// Scheduled is a publish held by the server until it is due
+type Scheduled struct {
+	Id        string    `json:"id"`
+	DeliverAt time.Time `json:"deliver_at"`
+	Header    *Header   `json:"header,omitempty"`
+	Publish   *Publish  `json:"publish"`
+}
+
+// Cancel is sent by client to server to drop a scheduled publish before it is due
+type Cancel struct {
+	Id string `json:"id"`
 }
 
 func (publish *Publish) ToBounty() *Bounty {
@@ -59,38 +142,148 @@ func (publish *Publish) ToBounty() *Bounty {
 		Subject: publish.Subject,
 		ReplyTo: publish.ReplyTo,
 		Body:    publish.Body,
+		Headers: publish.Headers,
+	}
+}
+
+// Request is sent by client to server when it expects a single reply
+type Request struct {
+	Subject string      `json:"subject"`              // The Subject to which the request must be delivered
+	Body    interface{} `json:"body"`                 // Body is the custom data that the client wants to send over
+	Headers Headers     `json:"headers,omitempty"`    // Headers is the metadata of the request
+	Timeout int         `json:"timeout_ms,omitempty"` // Timeout is the time in milliseconds to wait for a reply
+}
+
+// ToPublish converts the request into a publish whose replies are sent to inbox
+func (request *Request) ToPublish(inbox string) *Publish {
+	return &Publish{
+		Subject: request.Subject,
+		ReplyTo: inbox,
+		Body:    request.Body,
+		Headers: request.Headers,
 	}
 }
 
 type Subscribe struct {
-	Subject string `json:"subject"` // The list of Subject to subscribe to
+	Subject string  `json:"subject"`           // The list of Subject to subscribe to
+	Stream  string  `json:"stream,omitempty"`  // Stream binds the client to the Durable consumer of the stream, instead of subscribing to the Subject
+	Durable string  `json:"durable,omitempty"` // Durable is the name of the consumer to bind to
+	Replay  *Replay `json:"replay,omitempty"`  // Replay delivers the messages of the Subject stored in a stream before live ones
+	Filter  string  `json:"filter,omitempty"`  // Filter only delivers the messages whose headers and body match the expression, e.g. body.amount > 100 && header.region == "eu"
+}
+
+// Replay selects where to start delivering the stored messages from. All of them are delivered if no option is set.
+type Replay struct {
+	Stream         string     `json:"stream"`
+	StartSequence  uint64     `json:"start_seq,omitempty"`        // StartSequence starts from the message with this sequence
+	StartTime      *time.Time `json:"start_time,omitempty"`       // StartTime starts from the first message stored at or after this time
+	LastN          int        `json:"last_n,omitempty"`           // LastN starts from the last N messages
+	LastPerSubject bool       `json:"last_per_subject,omitempty"` // LastPerSubject delivers the last message of every subject
 }
 
 type Unsubscribe struct {
-	Subject string `json:"subject"` // The list of Subjects to unsubscribe from
+	Subject string `json:"subject"`           // The list of Subjects to unsubscribe from
+	Stream  string `json:"stream,omitempty"`  // Stream unbinds the client from the Durable consumer of the stream, or stops the replay of the Subject without a Durable
+	Durable string `json:"durable,omitempty"` // Durable is the name of the consumer to unbind from
+}
+
+// MessageAck is sent by client to server once it has processed a message delivered by a durable consumer
+type MessageAck struct {
+	Stream   string `json:"stream"`
+	Durable  string `json:"durable"`
+	Sequence uint64 `json:"seq"`
 }
 
 type Bounty struct {
-	Subject string      `json:"subject"`            // The Subject to which the message is intended
-	ReplyTo string      `json:"reply_to,omitempty"` // The ReplyTo subject
-	Body    interface{} `json:"body,omitempty"`
+	Subject    string      `json:"subject"`            // The Subject to which the message is intended
+	ReplyTo    string      `json:"reply_to,omitempty"` // The ReplyTo subject
+	Body       interface{} `json:"body,omitempty"`
+	Headers    Headers     `json:"headers,omitempty"`
+	Stream     string      `json:"stream,omitempty"`     // Stream is set for messages delivered by a durable consumer
+	Durable    string      `json:"durable,omitempty"`    // Durable is the consumer which delivered the message
+	Sequence   uint64      `json:"seq,omitempty"`        // Sequence is the stream sequence of the message, to be acknowledged
+	Deliveries int         `json:"deliveries,omitempty"` // Deliveries is the number of times the message has been delivered
+}
+
+// StoredMessage is a publish persisted in a stream
+type StoredMessage struct {
+	Sequence  uint64      `json:"seq"`
+	Timestamp time.Time   `json:"ts"`
+	Subject   string      `json:"subject"`
+	ReplyTo   string      `json:"reply_to,omitempty"`
+	Header    *Header     `json:"header,omitempty"`
+	Headers   Headers     `json:"headers,omitempty"`
+	Body      interface{} `json:"body,omitempty"`
+}
+
+// ToBounty converts the stored message for delivery by a durable consumer
+func (stored *StoredMessage) ToBounty(stream string, durable string, deliveries int) *Bounty {
+	return &Bounty{
+		Subject:    stored.Subject,
+		ReplyTo:    stored.ReplyTo,
+		Body:       stored.Body,
+		Headers:    stored.Headers,
+		Stream:     stream,
+		Durable:    durable,
+		Sequence:   stored.Sequence,
+		Deliveries: deliveries,
+	}
 }
 
 const (
-	ConnectionTypeTcp = "tcp"
+	ConnectionTypeTcp       = "tcp"
+	ConnectionTypeWebsocket = "websocket"
+	ConnectionTypeSse       = "sse"
 )
 
 type ClientConnection struct {
-	ClientUri          string   // ClientUri is a concatenation of ClientID:ClientGroup
-	SuppressAcks       bool     // SuppressAcks suppresses acknowledgements if client wants to disable them
-	SubscribedSubjects []string // SubscribedSubjects is the list of subjects the connection is subscribed to receive
-	ConnectionType     string   // ConnectionType is the type of connection
-	TcpConnection      net.Conn // TcpConnection is the net.Conn object for TCP clients. This might be other kinds of connection objects for other connection types.
+	Connected          bool                // Connected is set once the client has completed CONNECT
+	User               *User               // User is the authenticated user, if the client authenticated as one
+	ClientUri          string              // ClientUri is a concatenation of ClientID:ClientGroup
+	ClientGroup        string              // ClientGroup is the queue group of the client. Only one member of a group receives each message published on the same server, and none of those published on other servers.
+	SuppressAcks       bool                // SuppressAcks suppresses acknowledgements if client wants to disable them
+	SubscribedSubjects []string            // SubscribedSubjects is the list of subjects the connection is subscribed to receive
+	ConnectionType     string              // ConnectionType is the type of connection
+	TcpConnection      net.Conn            // TcpConnection is the net.Conn object for TCP clients. For other connection types, it is the underlying connection.
+	WsConnection       *websocket.Conn     // WsConnection is the WebSocket connection of WebSocket clients
+	HttpWriter         http.ResponseWriter // HttpWriter streams Server-Sent Events to SSE clients. It is nil once the request is done.
+	WriteLock          sync.Mutex          // WriteLock serializes the messages written to the client
+	MapUser            bool                // MapUser authenticates the client by its certificate, as configured on the listener which accepted it
+	LeafName           string              // LeafName is the server name of a leaf node, which its own messages are never sent back to. It is empty for other clients.
 }
 
 type PeerConnection struct {
+	Connected          bool // Connected is set once the peer has been verified
 	PeerName           string
 	PeerUri            string
 	TcpConnection      net.Conn
 	InterestedSubjects []string
+	WriteLock          sync.Mutex // WriteLock serializes the messages written to the peer
+	Dialed             bool       // Dialed is set on the connections this server dialed, rather than accepted
+	Duplicate          bool       // Duplicate is set once the connection is closed for another connection to the same peer
+}
+
+const (
+	RouteStateConnecting = "connecting"
+	RouteStateConnected  = "connected"
+	RouteStateBackingOff = "backing_off"
+	// RouteStateConnectedByPeer is the state of a route not dialed while the peer is connected by another connection, usually the one it dialed
+	RouteStateConnectedByPeer = "connected_by_peer"
+)
+
+// ClusterStatus is the state of the connections of a server to the other servers
+type ClusterStatus struct {
+	Routes []RouteStatus `json:"routes"`
+	Leaf   *RouteStatus  `json:"leaf,omitempty"` // Leaf is the connection to the hub, nil unless the server is a leaf node
+}
+
+// RouteStatus is the state of the connection dialed to a configured route
+type RouteStatus struct {
+	Name       string    `json:"name"`
+	Url        string    `json:"url"`
+	Discovered bool      `json:"discovered,omitempty"` // Discovered is set for routes learned through gossip rather than configured
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`                // Since is when the route entered its state
+	Failures   int       `json:"failures"`             // Failures counts the failed dials and lost connections since the route was last connected
+	LastError  string    `json:"last_error,omitempty"` // LastError is why the route last failed
 }
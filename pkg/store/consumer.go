@@ -0,0 +1,414 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/routing"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAckWait       = 30 * time.Second
+	DefaultMaxAckPending = 1000
+	consumersDir         = "consumers"
+)
+
+var (
+	AckNotPendingError   = errors.New("message is not pending acknowledgement")
+	DeliveryRefusedError = errors.New("client may not receive the message")
+)
+
+// DeliverFunc sends a message to a client bound to a consumer. An error unbinds the client,
+// except DeliveryRefusedError which passes the message on to the next client.
+type DeliverFunc func(stored *schemas.StoredMessage, deliveries int) error
+
+// DeadLetterFunc publishes a message routed to a dead-letter subject
+type DeadLetterFunc func(msg *schemas.Message)
+
+// Consumer delivers the messages of a stream to its bound clients in turn,
+// and redelivers those not acknowledged within the ack wait.
+// Its progress is saved after every change, so that it resumes where it left off after a restart.
+type Consumer struct {
+	config  *schemas.Consumer
+	stream  *Stream
+	path    string
+	ackWait time.Duration
+
+	lock    sync.Mutex
+	state   *consumerState
+	clients []*boundClient
+	cursor  int
+
+	signal chan struct{}
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+type consumerState struct {
+	Delivered uint64                 `json:"delivered"` // Delivered is the last stream sequence delivered. Messages up to it which are not pending have been acknowledged.
+	Pending   map[uint64]*pendingAck `json:"pending"`
+}
+
+type pendingAck struct {
+	Deliveries  int       `json:"deliveries"`
+	DeliveredAt time.Time `json:"delivered_at"`
+}
+
+type boundClient struct {
+	client  interface{}
+	deliver DeliverFunc
+}
+
+// outgoing is a message taken for delivery, which is written to a client once the consumer lock is released
+type outgoing struct {
+	stored     *schemas.StoredMessage
+	deliveries int
+}
+
+func openConsumer(stream *Stream, config *schemas.Consumer) (*Consumer, error) {
+	dir := filepath.Join(stream.dir, consumersDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ackWait := DefaultAckWait
+	if config.AckWait > 0 {
+		ackWait = time.Duration(config.AckWait) * time.Millisecond
+	}
+
+	c := &Consumer{
+		config:  config,
+		stream:  stream,
+		path:    filepath.Join(dir, config.Name+".json"),
+		ackWait: ackWait,
+		state:   &consumerState{Pending: make(map[uint64]*pendingAck)},
+		signal:  make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	b, err := ioutil.ReadFile(c.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, c.state); err != nil {
+			return nil, err
+		}
+		if c.state.Pending == nil {
+			c.state.Pending = make(map[uint64]*pendingAck)
+		}
+	}
+
+	go c.run()
+	return c, nil
+}
+
+func (c *Consumer) Name() string {
+	return c.config.Name
+}
+
+// Subjects returns the subjects the consumer can deliver messages from
+func (c *Consumer) Subjects() []string {
+	if len(c.config.FilterSubject) > 0 {
+		return []string{c.config.FilterSubject}
+	}
+	return c.stream.config.Subjects
+}
+
+// Bind adds the client to those receiving messages from the consumer
+func (c *Consumer) Bind(client interface{}, deliver DeliverFunc) {
+	c.lock.Lock()
+	c.clients = append(c.clients, &boundClient{client: client, deliver: deliver})
+	c.lock.Unlock()
+	c.notify()
+}
+
+func (c *Consumer) Unbind(client interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.unbind(client)
+}
+
+// Ack acknowledges the message, which is never delivered again
+func (c *Consumer) Ack(seq uint64) error {
+	c.lock.Lock()
+	if _, ok := c.state.Pending[seq]; !ok {
+		c.lock.Unlock()
+		return AckNotPendingError
+	}
+	delete(c.state.Pending, seq)
+	c.save()
+	c.lock.Unlock()
+
+	c.stream.acknowledged(seq)
+
+	// Acknowledging may have made room for more messages
+	c.notify()
+	return nil
+}
+
+// hasAcked tells if the message has been delivered and acknowledged
+func (c *Consumer) hasAcked(seq uint64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, pending := c.state.Pending[seq]
+	return seq <= c.state.Delivered && !pending
+}
+
+// notify wakes up the consumer to deliver new messages
+func (c *Consumer) notify() {
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+// stop ends the delivery of messages, and waits for the state to be saved a last time
+func (c *Consumer) stop() {
+	close(c.quit)
+	<-c.done
+}
+
+func (c *Consumer) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.ackWait / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-c.signal:
+		case <-ticker.C:
+		}
+
+		// Clients are written to without holding the consumer lock, so that a slow one does not hold up acks.
+		// Dead letters are too, since they may be stored in the same stream, and so is retention checked.
+		messages, givenUp, deadLetters := c.deliverMessages()
+		for _, out := range messages {
+			c.send(out)
+		}
+		for _, msg := range deadLetters {
+			c.stream.deadLetter(msg)
+		}
+		// Giving up on a message is final, so retention treats it like an ack
+		for _, seq := range givenUp {
+			c.stream.acknowledged(seq)
+		}
+	}
+}
+
+// deliverMessages takes the messages whose ack wait has passed for redelivery, then new ones.
+// It returns the messages to send, the sequences given up on, and the dead letters to publish for them.
+func (c *Consumer) deliverMessages() ([]*outgoing, []uint64, []*schemas.Message) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.clients) == 0 {
+		return nil, nil, nil
+	}
+
+	var messages []*outgoing
+	var givenUp []uint64
+	var deadLetters []*schemas.Message
+
+	now := time.Now()
+	changed := false
+	for _, seq := range c.pendingSequences() {
+		pending := c.state.Pending[seq]
+		if now.Sub(pending.DeliveredAt) < c.ackWait {
+			continue
+		}
+
+		changed = true
+		if c.config.MaxDeliveries > 0 && pending.Deliveries >= c.config.MaxDeliveries {
+			log.Printf("Consumer %s gave up on message %d after %d deliveries\n", c.config.Name, seq, pending.Deliveries)
+			delete(c.state.Pending, seq)
+			givenUp = append(givenUp, seq)
+			if msg := c.toDeadLetter(seq, pending.Deliveries); msg != nil {
+				deadLetters = append(deadLetters, msg)
+			}
+			continue
+		}
+
+		stored, err := c.stream.Get(seq)
+		if err == MessageNotFoundError {
+			// The message is no longer in the stream
+			delete(c.state.Pending, seq)
+			continue
+		}
+		if err != nil {
+			// Kept pending, so that it is retried on the next round
+			log.Printf("Consumer %s failed to read message %d: %v\n", c.config.Name, seq, err)
+			continue
+		}
+		messages = append(messages, c.take(stored, pending))
+	}
+
+	maxAckPending := c.config.MaxAckPending
+	if maxAckPending <= 0 {
+		maxAckPending = DefaultMaxAckPending
+	}
+
+	_, last, _ := c.stream.State()
+	for len(c.state.Pending) < maxAckPending && c.state.Delivered < last && len(c.clients) > 0 {
+		stored, err := c.stream.Get(c.state.Delivered + 1)
+		if err != nil && err != MessageNotFoundError {
+			// Delivery resumes from this message on the next round
+			log.Printf("Consumer %s failed to read message %d: %v\n", c.config.Name, c.state.Delivered+1, err)
+			break
+		}
+		changed = true
+		c.state.Delivered++
+		if err != nil {
+			continue
+		}
+		if len(c.config.FilterSubject) > 0 && !routing.MatchSubject(stored.Subject, c.config.FilterSubject) {
+			continue
+		}
+
+		pending := &pendingAck{}
+		c.state.Pending[stored.Sequence] = pending
+		messages = append(messages, c.take(stored, pending))
+	}
+
+	if changed {
+		c.save()
+	}
+	return messages, givenUp, deadLetters
+}
+
+// toDeadLetter returns the message to publish to the dead-letter subject, or nil if there is none
+func (c *Consumer) toDeadLetter(seq uint64, deliveries int) *schemas.Message {
+	if len(c.config.DeadLetterSubject) == 0 {
+		return nil
+	}
+
+	stored, err := c.stream.Get(seq)
+	if err != nil {
+		return nil
+	}
+
+	return &schemas.Message{
+		Kind: schemas.KindPublish,
+		Header: &schemas.Header{DeadLetter: &schemas.DeadLetter{
+			Reason:     schemas.DeadLetterMaxDeliveries,
+			Subject:    stored.Subject,
+			Deliveries: deliveries,
+			Stream:     c.stream.Name(),
+			Durable:    c.config.Name,
+			Sequence:   seq,
+		}},
+		Publish: &schemas.Publish{
+			Subject: c.config.DeadLetterSubject,
+			ReplyTo: stored.ReplyTo,
+			Body:    stored.Body,
+			Headers: stored.Headers,
+		},
+	}
+}
+
+// take counts a delivery of the message, and starts its ack wait
+func (c *Consumer) take(stored *schemas.StoredMessage, pending *pendingAck) *outgoing {
+	pending.Deliveries++
+	pending.DeliveredAt = time.Now()
+	return &outgoing{stored: stored, deliveries: pending.Deliveries}
+}
+
+// send delivers the message to the next bound client able to receive it.
+// When every client refuses it, the message is held and offered again on the next round.
+func (c *Consumer) send(out *outgoing) {
+	c.lock.Lock()
+	tries := len(c.clients)
+	c.lock.Unlock()
+
+	for ; tries > 0; tries-- {
+		bound := c.nextClient()
+		if bound == nil {
+			break
+		}
+		err := bound.deliver(out.stored, out.deliveries)
+		if err == DeliveryRefusedError {
+			continue
+		}
+		if err != nil {
+			log.Printf("Consumer %s failed to deliver: %v\n", c.config.Name, err)
+			c.Unbind(bound.client)
+			continue
+		}
+		return
+	}
+	c.hold(out.stored.Sequence)
+}
+
+// nextClient returns the bound client whose turn it is, or nil if there is none
+func (c *Consumer) nextClient() *boundClient {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.clients) == 0 {
+		return nil
+	}
+	bound := c.clients[c.cursor%len(c.clients)]
+	c.cursor++
+	return bound
+}
+
+// hold takes back the delivery of a message nobody received, which then neither counts nor waits for the ack wait
+func (c *Consumer) hold(seq uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	pending, ok := c.state.Pending[seq]
+	if !ok {
+		return
+	}
+	pending.Deliveries--
+	pending.DeliveredAt = time.Time{}
+	c.save()
+}
+
+func (c *Consumer) unbind(client interface{}) {
+	for i, bound := range c.clients {
+		if bound.client == client {
+			c.clients = append(c.clients[:i], c.clients[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *Consumer) pendingSequences() []uint64 {
+	seqs := make([]uint64, 0, len(c.state.Pending))
+	for seq := range c.state.Pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
+}
+
+// save atomically replaces the state file, so that a crash never leaves it half written
+func (c *Consumer) save() {
+	b, err := json.Marshal(c.state)
+	if err != nil {
+		log.Println("Failed to save consumer state:", err)
+		return
+	}
+
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		log.Println("Failed to save consumer state:", err)
+		return
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		log.Println("Failed to save consumer state:", err)
+	}
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"testing"
+	"time"
+)
+
+type delivery struct {
+	seq        uint64
+	deliveries int
+}
+
+func bindCollector(c *Consumer) chan delivery {
+	ch := make(chan delivery, 100)
+	c.Bind(ch, func(stored *schemas.StoredMessage, deliveries int) error {
+		ch <- delivery{stored.Sequence, deliveries}
+		return nil
+	})
+	return ch
+}
+
+func receive(t *testing.T, ch chan delivery) delivery {
+	select {
+	case d := <-ch:
+		return d
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a delivery")
+		return delivery{}
+	}
+}
+
+func TestConsumerRedeliversAndResumes(t *testing.T) {
+	dir := t.TempDir()
+	config := &schemas.Stream{
+		Name:      "orders",
+		Subjects:  []string{"orders.>"},
+		Consumers: []*schemas.Consumer{{Name: "billing", AckWait: 100}},
+	}
+
+	s, err := OpenStream(dir, config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	appendN(t, s, 2)
+
+	ch := bindCollector(s.Consumer("billing"))
+	if d := receive(t, ch); d != (delivery{1, 1}) {
+		t.Fatalf("first delivery = %+v", d)
+	}
+	if d := receive(t, ch); d != (delivery{2, 1}) {
+		t.Fatalf("second delivery = %+v", d)
+	}
+	if err := s.Consumer("billing").Ack(1); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+
+	// Only the unacknowledged message is delivered again
+	if d := receive(t, ch); d != (delivery{2, 2}) {
+		t.Fatalf("redelivery = %+v", d)
+	}
+	s.Close()
+
+	s, err = OpenStream(dir, config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	ch = bindCollector(s.Consumer("billing"))
+	if d := receive(t, ch); d != (delivery{2, 3}) {
+		t.Fatalf("delivery after restart = %+v", d)
+	}
+	if err := s.Consumer("billing").Ack(1); err != AckNotPendingError {
+		t.Errorf("Ack() of an acknowledged message error = %v, want %v", err, AckNotPendingError)
+	}
+}
+
+func TestConsumerDeadLetters(t *testing.T) {
+	config := &schemas.Stream{
+		Name:     "orders",
+		Subjects: []string{"orders.>"},
+		Consumers: []*schemas.Consumer{{
+			Name:              "billing",
+			AckWait:           50,
+			MaxDeliveries:     2,
+			DeadLetterSubject: "dead.billing",
+		}},
+	}
+
+	s, err := OpenStream(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	deadLetters := make(chan *schemas.Message, 1)
+	s.OnDeadLetter(func(msg *schemas.Message) {
+		deadLetters <- msg
+	})
+
+	appendN(t, s, 1)
+	ch := bindCollector(s.Consumer("billing"))
+	receive(t, ch)
+	receive(t, ch)
+
+	select {
+	case msg := <-deadLetters:
+		deadLetter := msg.Header.DeadLetter
+		if msg.Publish.Subject != "dead.billing" || deadLetter.Subject != "orders.created" || deadLetter.Deliveries != 2 || deadLetter.Reason != schemas.DeadLetterMaxDeliveries {
+			t.Errorf("dead letter = %+v, %+v", msg.Publish, deadLetter)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the dead letter")
+	}
+}
+
+func TestConsumerPassesOnRefusedMessages(t *testing.T) {
+	config := &schemas.Stream{
+		Name:      "orders",
+		Subjects:  []string{"orders.>"},
+		Consumers: []*schemas.Consumer{{Name: "billing", AckWait: 100}},
+	}
+
+	s, err := OpenStream(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	consumer := s.Consumer("billing")
+	refused := make(chan delivery, 100)
+	consumer.Bind(refused, func(stored *schemas.StoredMessage, deliveries int) error {
+		refused <- delivery{stored.Sequence, deliveries}
+		return DeliveryRefusedError
+	})
+	appendN(t, s, 1)
+
+	// The only client refuses the message, which is held rather than dropped
+	receive(t, refused)
+	consumer.Unbind(refused)
+
+	ch := bindCollector(consumer)
+	if d := receive(t, ch); d != (delivery{1, 1}) {
+		t.Fatalf("delivery to the next client = %+v", d)
+	}
+}
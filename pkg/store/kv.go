@@ -0,0 +1,176 @@
+package store
+
+import (
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"strings"
+	"unicode"
+)
+
+const (
+	KvSubjectPrefix  = "$KV."
+	KvStreamPrefix   = "KV_"
+	DefaultKvHistory = 1
+)
+
+var (
+	KeyNotFoundError       = errors.New("key not found")
+	InvalidKeyError        = errors.New("invalid key")
+	WrongLastRevisionError = errors.New("wrong last revision for the key")
+)
+
+// Bucket is a key-value store kept in a stream.
+// Every key is a subject of the stream, and the sequence a value is stored at is its revision.
+type Bucket struct {
+	name   string
+	stream *Stream
+}
+
+// bucketStream returns the configuration of the stream keeping the bucket,
+// which only holds the configured number of revisions of each key
+func bucketStream(config *schemas.Bucket) *schemas.Stream {
+	history := config.History
+	if history <= 0 {
+		history = DefaultKvHistory
+	}
+
+	return &schemas.Stream{
+		Name:                  KvStreamPrefix + config.Name,
+		Subjects:              []string{KvSubjectPrefix + config.Name + ".>"},
+		Sync:                  config.Sync,
+		MaxBytes:              config.MaxBytes,
+		MaxMessagesPerSubject: history,
+	}
+}
+
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+func (b *Bucket) Stream() *Stream {
+	return b.stream
+}
+
+// Subject returns the subject the revisions of the key are stored on
+func (b *Bucket) Subject(key string) string {
+	return KvSubjectPrefix + b.name + "." + key
+}
+
+// Put stores a new revision of the key. A non nil expected revision makes it a compare-and-set.
+func (b *Bucket) Put(key string, value interface{}, expected *uint64) (*schemas.KvEntry, error) {
+	return b.store(key, value, nil, expected)
+}
+
+// Delete stores a revision marking the key as deleted, keeping its history
+func (b *Bucket) Delete(key string, expected *uint64) (*schemas.KvEntry, error) {
+	return b.store(key, nil, &schemas.Header{KvOperation: schemas.KvOperationDelete}, expected)
+}
+
+func (b *Bucket) store(key string, value interface{}, header *schemas.Header, expected *uint64) (*schemas.KvEntry, error) {
+	if !ValidKey(key, false) {
+		return nil, InvalidKeyError
+	}
+
+	publish := &schemas.Publish{Subject: b.Subject(key), Body: value}
+	stored, err := b.stream.AppendExpected(publish, header, expected)
+	if err == WrongLastSequenceError {
+		return nil, WrongLastRevisionError
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.Entry(stored), nil
+}
+
+// Get returns the latest revision of the key, unless it was deleted
+func (b *Bucket) Get(key string) (*schemas.KvEntry, error) {
+	if !ValidKey(key, false) {
+		return nil, InvalidKeyError
+	}
+
+	stored, err := b.stream.LastBySubject(b.Subject(key))
+	if err == MessageNotFoundError {
+		return nil, KeyNotFoundError
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := b.Entry(stored)
+	if entry.Operation == schemas.KvOperationDelete {
+		return nil, KeyNotFoundError
+	}
+	return entry, nil
+}
+
+// History returns the revisions of the key still kept, oldest first
+func (b *Bucket) History(key string) ([]*schemas.KvEntry, error) {
+	if !ValidKey(key, false) {
+		return nil, InvalidKeyError
+	}
+
+	messages, err := b.stream.BySubject(b.Subject(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, KeyNotFoundError
+	}
+
+	entries := make([]*schemas.KvEntry, 0, len(messages))
+	for _, stored := range messages {
+		entries = append(entries, b.Entry(stored))
+	}
+	return entries, nil
+}
+
+// Watch sends the latest revision of every key matching the pattern, then every new revision
+func (b *Bucket) Watch(pattern string, fn func(entry *schemas.KvEntry) error) (*Replay, error) {
+	if !ValidKey(pattern, true) {
+		return nil, InvalidKeyError
+	}
+
+	return b.stream.Replay(b.Subject(pattern), &schemas.Replay{LastPerSubject: true}, func(stored *schemas.StoredMessage) error {
+		return fn(b.Entry(stored))
+	})
+}
+
+// Entry converts a message of the bucket stream to the revision of its key
+func (b *Bucket) Entry(stored *schemas.StoredMessage) *schemas.KvEntry {
+	entry := &schemas.KvEntry{
+		Bucket:    b.name,
+		Key:       strings.TrimPrefix(stored.Subject, KvSubjectPrefix+b.name+"."),
+		Value:     stored.Body,
+		Revision:  stored.Sequence,
+		Timestamp: stored.Timestamp,
+		Operation: schemas.KvOperationPut,
+	}
+	if stored.Header != nil && stored.Header.KvOperation == schemas.KvOperationDelete {
+		entry.Operation = schemas.KvOperationDelete
+	}
+	return entry
+}
+
+// ValidKey tells if the key is made of non-empty subject tokens, which may only be wildcards if allowed
+func ValidKey(key string, wildcards bool) bool {
+	if len(key) == 0 {
+		return false
+	}
+
+	tokens := strings.Split(key, ".")
+	for i, token := range tokens {
+		if len(token) == 0 || strings.IndexFunc(token, unicode.IsSpace) > -1 {
+			return false
+		}
+		if token == "*" || token == ">" {
+			if !wildcards || (token == ">" && i != len(tokens)-1) {
+				return false
+			}
+			continue
+		}
+		if strings.ContainsAny(token, "*>") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"testing"
+	"time"
+)
+
+func TestBucketPutGetDelete(t *testing.T) {
+	b := openStore(t, &schemas.Storage{Buckets: []*schemas.Bucket{{Name: "flags", History: 2}}}).Bucket("flags")
+
+	if _, err := b.Get("dark.mode"); err != KeyNotFoundError {
+		t.Fatalf("Get() error = %v, want %v", err, KeyNotFoundError)
+	}
+
+	for _, value := range []string{"off", "on", "beta"} {
+		if _, err := b.Put("dark.mode", value, nil); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	entry, err := b.Get("dark.mode")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if entry.Value != "beta" || entry.Revision != 3 {
+		t.Errorf("Get() = %+v", entry)
+	}
+
+	// Only the configured number of revisions is kept
+	history, err := b.History("dark.mode")
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(history) != 2 || history[0].Value != "on" {
+		t.Errorf("History() = %+v", history)
+	}
+
+	if _, err := b.Delete("dark.mode", nil); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := b.Get("dark.mode"); err != KeyNotFoundError {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, KeyNotFoundError)
+	}
+}
+
+func TestBucketCompareAndSet(t *testing.T) {
+	b := openStore(t, &schemas.Storage{Buckets: []*schemas.Bucket{{Name: "leases"}}}).Bucket("leases")
+	none := uint64(0)
+
+	entry, err := b.Put("leader", "a", &none)
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, err := b.Put("leader", "b", &none); err != WrongLastRevisionError {
+		t.Errorf("Put() on existing key error = %v, want %v", err, WrongLastRevisionError)
+	}
+	if _, err := b.Put("leader", "b", &entry.Revision); err != nil {
+		t.Errorf("Put() with latest revision error = %v", err)
+	}
+	if _, err := b.Put("leader", "c", &entry.Revision); err != WrongLastRevisionError {
+		t.Errorf("Put() with stale revision error = %v, want %v", err, WrongLastRevisionError)
+	}
+}
+
+func TestBucketCreateAfterDelete(t *testing.T) {
+	b := openStore(t, &schemas.Storage{Buckets: []*schemas.Bucket{{Name: "leases", History: 5}}}).Bucket("leases")
+
+	none := uint64(0)
+	if _, err := b.Put("leader", "a", &none); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, err := b.Delete("leader", nil); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	// The delete marker is the latest revision, yet the key is absent and can be created again
+	entry, err := b.Put("leader", "b", &none)
+	if err != nil {
+		t.Fatalf("Put() of a deleted key error = %v", err)
+	}
+	if _, err := b.Put("leader", "c", &none); err != WrongLastRevisionError {
+		t.Errorf("Put() on the recreated key error = %v, want %v", err, WrongLastRevisionError)
+	}
+	if got, err := b.Get("leader"); err != nil || got.Revision != entry.Revision {
+		t.Errorf("Get() = %+v, %v", got, err)
+	}
+}
+
+func TestBucketWatch(t *testing.T) {
+	b := openStore(t, &schemas.Storage{Buckets: []*schemas.Bucket{{Name: "config"}}}).Bucket("config")
+	b.Put("svc.a.port", 80.0, nil)
+	b.Put("svc.b.port", 81.0, nil)
+	b.Put("other", true, nil)
+
+	ch := make(chan *schemas.KvEntry, 10)
+	watch, err := b.Watch("svc.*.port", func(entry *schemas.KvEntry) error {
+		ch <- entry
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer watch.Stop()
+
+	expect := func(want string) {
+		select {
+		case entry := <-ch:
+			if got := entry.Key + " " + entry.Operation; got != want {
+				t.Fatalf("watched %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	expect("svc.a.port put")
+	expect("svc.b.port put")
+	b.Delete("svc.a.port", nil)
+	expect("svc.a.port delete")
+}
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key       string
+		wildcards bool
+		want      bool
+	}{
+		{"a.b", false, true},
+		{"a..b", false, false},
+		{"", false, false},
+		{"a b", false, false},
+		{"a.*", false, false},
+		{"a.*", true, true},
+		{"a.>", true, true},
+		{">.a", true, false},
+		{"a*", true, false},
+	}
+	for _, tt := range tests {
+		if got := ValidKey(tt.key, tt.wildcards); got != tt.want {
+			t.Errorf("ValidKey(%q, %v) = %v, want %v", tt.key, tt.wildcards, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"hash"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ObjectSubjectPrefix      = "$O."
+	ObjectStreamPrefix       = "OBJ_"
+	DefaultObjectChunkBytes  = 128 << 10
+	objectChunksToken        = ".C."
+	objectInfoToken          = ".M."
+	objectInfoWildcardSuffix = ">"
+)
+
+var (
+	ObjectNotFoundError   = errors.New("object not found")
+	InvalidObjectError    = errors.New("invalid object name")
+	CorruptedObjectError  = errors.New("object chunks do not match its digest")
+	ObjectWriterDoneError = errors.New("object is already complete")
+	ObjectMaxBytesError   = errors.New("object stores do not support max_bytes, since deleting the oldest chunks would corrupt objects")
+)
+
+// ObjectStore keeps large objects in a stream.
+// The chunks of an object are stored on a subject of their own, followed by the object info once they all are,
+// so that readers never see a partially stored object.
+type ObjectStore struct {
+	name       string
+	stream     *Stream
+	chunkBytes int
+
+	// lock serializes replacing the info of objects, and the removal of the chunks it made obsolete
+	lock sync.Mutex
+}
+
+// objectStream returns the configuration of the stream keeping the object store
+func objectStream(config *schemas.Objects) *schemas.Stream {
+	return &schemas.Stream{
+		Name:     ObjectStreamPrefix + config.Name,
+		Subjects: []string{ObjectSubjectPrefix + config.Name + ".>"},
+		Sync:     config.Sync,
+	}
+}
+
+func newObjectStore(config *schemas.Objects, stream *Stream) *ObjectStore {
+	chunkBytes := config.ChunkBytes
+	if chunkBytes <= 0 {
+		chunkBytes = DefaultObjectChunkBytes
+	}
+	return &ObjectStore{name: config.Name, stream: stream, chunkBytes: chunkBytes}
+}
+
+func (o *ObjectStore) Name() string {
+	return o.name
+}
+
+func (o *ObjectStore) Stream() *Stream {
+	return o.stream
+}
+
+// InfoSubject returns the subject the info of the named object is stored on.
+// Names are encoded, since they may contain characters which are not allowed in a subject token.
+func (o *ObjectStore) InfoSubject(name string) string {
+	return ObjectSubjectPrefix + o.name + objectInfoToken + base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+func (o *ObjectStore) chunksSubject(id string) string {
+	return ObjectSubjectPrefix + o.name + objectChunksToken + id
+}
+
+// ObjectWriter stores the chunks of an object as its data is written
+type ObjectWriter struct {
+	store   *ObjectStore
+	info    *schemas.ObjectInfo
+	digest  hash.Hash
+	pending []byte
+	done    bool
+}
+
+// Create starts storing a new version of the named object, which replaces the current one once closed
+func (o *ObjectStore) Create(name string) (*ObjectWriter, error) {
+	if len(name) == 0 {
+		return nil, InvalidObjectError
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &ObjectWriter{
+		store:  o,
+		info:   &schemas.ObjectInfo{Store: o.name, Name: name, Id: hex.EncodeToString(id)},
+		digest: sha256.New(),
+	}, nil
+}
+
+// Write stores the data, in as many chunks as needed
+func (w *ObjectWriter) Write(data []byte) error {
+	if w.done {
+		return ObjectWriterDoneError
+	}
+
+	w.digest.Write(data)
+	w.info.Size += int64(len(data))
+	w.pending = append(w.pending, data...)
+	for len(w.pending) >= w.store.chunkBytes {
+		if err := w.flush(w.store.chunkBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *ObjectWriter) flush(n int) error {
+	chunk := w.pending[:n]
+	publish := &schemas.Publish{Subject: w.store.chunksSubject(w.info.Id), Body: chunk}
+	if _, err := w.store.stream.Append(publish, nil); err != nil {
+		return err
+	}
+
+	w.pending = append([]byte(nil), w.pending[n:]...)
+	w.info.Chunks++
+	return nil
+}
+
+// Close stores the last chunk and the object info, then removes the chunks of the version it replaced
+func (w *ObjectWriter) Close() (*schemas.ObjectInfo, error) {
+	if w.done {
+		return nil, ObjectWriterDoneError
+	}
+
+	if len(w.pending) > 0 {
+		if err := w.flush(len(w.pending)); err != nil {
+			w.Abort()
+			return nil, err
+		}
+	}
+	w.done = true
+
+	w.info.Digest = hex.EncodeToString(w.digest.Sum(nil))
+	w.info.Timestamp = time.Now().UTC()
+	if err := w.store.replace(w.info); err != nil {
+		w.store.stream.Purge(w.store.chunksSubject(w.info.Id), 0)
+		return nil, err
+	}
+	return w.info, nil
+}
+
+// Abort removes the chunks stored so far
+func (w *ObjectWriter) Abort() {
+	w.done = true
+	w.store.stream.Purge(w.store.chunksSubject(w.info.Id), 0)
+}
+
+// removeOrphans removes the chunks no object info refers to, which uploads interrupted by a crash leave behind.
+// It is only called as the store is opened, since the chunks of objects being written have no info yet.
+func (o *ObjectStore) removeOrphans() error {
+	chunksPrefix := ObjectSubjectPrefix + o.name + objectChunksToken
+	infoPrefix := ObjectSubjectPrefix + o.name + objectInfoToken
+
+	o.stream.lock.RLock()
+	chunks := make([]string, 0)
+	infos := make([]string, 0)
+	for subject := range o.stream.subjects {
+		if strings.HasPrefix(subject, chunksPrefix) {
+			chunks = append(chunks, subject)
+		} else if strings.HasPrefix(subject, infoPrefix) {
+			infos = append(infos, subject)
+		}
+	}
+	o.stream.lock.RUnlock()
+
+	ids := make(map[string]struct{}, len(infos))
+	for _, subject := range infos {
+		info, err := o.info(subject)
+		if err == ObjectNotFoundError {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ids[info.Id] = struct{}{}
+	}
+
+	for _, subject := range chunks {
+		if _, ok := ids[strings.TrimPrefix(subject, chunksPrefix)]; ok {
+			continue
+		}
+		if err := o.stream.Purge(subject, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete stores an info marking the object as deleted, so that watchers learn about it, and removes its chunks
+func (o *ObjectStore) Delete(name string) error {
+	if _, err := o.Info(name); err != nil {
+		return err
+	}
+
+	return o.replace(&schemas.ObjectInfo{
+		Store:     o.name,
+		Name:      name,
+		Timestamp: time.Now().UTC(),
+		Deleted:   true,
+	})
+}
+
+func (o *ObjectStore) replace(info *schemas.ObjectInfo) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	subject := o.InfoSubject(info.Name)
+	previous, err := o.info(subject)
+	if err != nil && err != ObjectNotFoundError {
+		return err
+	}
+
+	if _, err := o.stream.Append(&schemas.Publish{Subject: subject, Body: info}, nil); err != nil {
+		return err
+	}
+	if err := o.stream.Purge(subject, 1); err != nil {
+		return err
+	}
+	if previous != nil && len(previous.Id) > 0 {
+		return o.stream.Purge(o.chunksSubject(previous.Id), 0)
+	}
+	return nil
+}
+
+// Info returns the info of the named object, unless it was deleted
+func (o *ObjectStore) Info(name string) (*schemas.ObjectInfo, error) {
+	info, err := o.info(o.InfoSubject(name))
+	if err != nil {
+		return nil, err
+	}
+	if info.Deleted {
+		return nil, ObjectNotFoundError
+	}
+	return info, nil
+}
+
+func (o *ObjectStore) info(subject string) (*schemas.ObjectInfo, error) {
+	stored, err := o.stream.LastBySubject(subject)
+	if err == MessageNotFoundError {
+		return nil, ObjectNotFoundError
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info schemas.ObjectInfo
+	if err := decodeBody(stored, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Get sends the chunks of the named object in order, checking them against its digest
+func (o *ObjectStore) Get(name string, fn func(data []byte) error) (*schemas.ObjectInfo, error) {
+	info, err := o.Info(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunks are read one at a time, so that large objects are never held in memory
+	seqs := o.stream.sequences(o.chunksSubject(info.Id))
+	if len(seqs) != info.Chunks {
+		return nil, CorruptedObjectError
+	}
+
+	digest := sha256.New()
+	for _, seq := range seqs {
+		stored, err := o.stream.Get(seq)
+		if err != nil {
+			return nil, err
+		}
+		encoded, _ := stored.Body.(string)
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, CorruptedObjectError
+		}
+		digest.Write(data)
+		if err := fn(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if hex.EncodeToString(digest.Sum(nil)) != info.Digest {
+		return nil, CorruptedObjectError
+	}
+	return info, nil
+}
+
+// List returns the info of every object which has not been deleted
+func (o *ObjectStore) List() ([]*schemas.ObjectInfo, error) {
+	prefix := ObjectSubjectPrefix + o.name + objectInfoToken
+
+	o.stream.lock.RLock()
+	subjects := make([]string, 0)
+	for subject := range o.stream.subjects {
+		if strings.HasPrefix(subject, prefix) {
+			subjects = append(subjects, subject)
+		}
+	}
+	o.stream.lock.RUnlock()
+
+	infos := make([]*schemas.ObjectInfo, 0, len(subjects))
+	for _, subject := range subjects {
+		info, err := o.info(subject)
+		if err == ObjectNotFoundError {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.Deleted {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// Watch sends the info of every object, then every new info as objects are stored or deleted
+func (o *ObjectStore) Watch(fn func(info *schemas.ObjectInfo) error) (*Replay, error) {
+	return o.stream.Replay(o.WatchSubject(), &schemas.Replay{LastPerSubject: true}, func(stored *schemas.StoredMessage) error {
+		var info schemas.ObjectInfo
+		if err := decodeBody(stored, &info); err != nil {
+			return err
+		}
+		return fn(&info)
+	})
+}
+
+// WatchSubject returns the subject the replays started by Watch are filtered on
+func (o *ObjectStore) WatchSubject() string {
+	return ObjectSubjectPrefix + o.name + objectInfoToken + objectInfoWildcardSuffix
+}
+
+// decodeBody converts the body of a stored message, which is read back as a generic JSON value
+func decodeBody(stored *schemas.StoredMessage, v interface{}) error {
+	b, err := json.Marshal(stored.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"bytes"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"testing"
+)
+
+func putObject(t *testing.T, o *ObjectStore, name string, parts ...[]byte) *schemas.ObjectInfo {
+	w, err := o.Create(name)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	for _, part := range parts {
+		if err := w.Write(part); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	info, err := w.Close()
+	if err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return info
+}
+
+func TestObjectStorePutGet(t *testing.T) {
+	o := openStore(t, &schemas.Storage{Objects: []*schemas.Objects{{Name: "models", ChunkBytes: 4}}}).Objects("models")
+
+	info := putObject(t, o, "model v1.bin", []byte("hello "), []byte("world"))
+	if info.Size != 11 || info.Chunks != 3 {
+		t.Errorf("Close() = %+v, want 11 bytes in 3 chunks", info)
+	}
+
+	var got bytes.Buffer
+	if _, err := o.Get("model v1.bin", func(data []byte) error {
+		got.Write(data)
+		return nil
+	}); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.String() != "hello world" {
+		t.Errorf("Get() = %q", got.String())
+	}
+
+	// Replacing the object removes the chunks of the previous version
+	putObject(t, o, "model v1.bin", []byte("bye"))
+	if _, _, count := o.Stream().State(); count != 2 {
+		t.Errorf("stream holds %d messages, want 2", count)
+	}
+
+	infos, err := o.List()
+	if err != nil || len(infos) != 1 || infos[0].Size != 3 {
+		t.Errorf("List() = %+v, %v", infos, err)
+	}
+}
+
+func TestObjectStoreDeleteAndAbort(t *testing.T) {
+	o := openStore(t, &schemas.Storage{Objects: []*schemas.Objects{{Name: "exports", ChunkBytes: 4}}}).Objects("exports")
+	putObject(t, o, "report.csv", []byte("a,b,c"))
+
+	w, err := o.Create("partial.csv")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	w.Write([]byte("12345678"))
+	w.Abort()
+	if _, err := o.Info("partial.csv"); err != ObjectNotFoundError {
+		t.Errorf("Info() of aborted object error = %v, want %v", err, ObjectNotFoundError)
+	}
+
+	if err := o.Delete("report.csv"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := o.Get("report.csv", func([]byte) error { return nil }); err != ObjectNotFoundError {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, ObjectNotFoundError)
+	}
+
+	// Only the deletion marker is left
+	if _, _, count := o.Stream().State(); count != 1 {
+		t.Errorf("stream holds %d messages, want 1", count)
+	}
+}
+
+func TestObjectStoreRemovesOrphanChunks(t *testing.T) {
+	config := &schemas.Storage{Dir: t.TempDir(), Objects: []*schemas.Objects{{Name: "models", ChunkBytes: 4}}}
+	s, err := NewStore(config)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	putObject(t, s.Objects("models"), "model.bin", []byte("hello"))
+
+	// The server stops before the upload is closed
+	w, err := s.Objects("models").Create("partial.bin")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	w.Write([]byte("12345678"))
+	s.Close()
+
+	s, err = NewStore(config)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	defer s.Close()
+
+	// Only the 2 chunks and the info of the stored object are left
+	o := s.Objects("models")
+	if _, _, count := o.Stream().State(); count != 3 {
+		t.Errorf("stream holds %d messages, want 3", count)
+	}
+	if _, err := o.Get("model.bin", func([]byte) error { return nil }); err != nil {
+		t.Errorf("Get() error = %v", err)
+	}
+}
+
+func TestObjectStoreRefusesMaxBytes(t *testing.T) {
+	_, err := NewStore(&schemas.Storage{Dir: t.TempDir(), Objects: []*schemas.Objects{{Name: "models", MaxBytes: 1 << 20}}})
+	if err != ObjectMaxBytesError {
+		t.Errorf("NewStore() error = %v, want %v", err, ObjectMaxBytesError)
+	}
+}
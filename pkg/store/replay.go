@@ -0,0 +1,188 @@
+package store
+
+import (
+	"github.com/tfes-dev/tfes/pkg/routing"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"log"
+	"sort"
+	"sync"
+)
+
+const (
+	replayBatch = 256
+)
+
+// ReplayFunc sends a replayed message to a client. An error stops the replay.
+type ReplayFunc func(stored *schemas.StoredMessage) error
+
+// Replay delivers the stored messages of a stream matching a subject, then every matching message appended afterwards.
+// The starting point is taken under the stream lock, so that the switch to new messages has neither gaps nor duplicates.
+type Replay struct {
+	stream  *Stream
+	filter  string
+	deliver ReplayFunc
+
+	backlog []uint64 // backlog holds the sequences to deliver before catching up from next
+	next    uint64
+
+	signal   chan struct{}
+	quit     chan struct{}
+	stopOnce sync.Once
+}
+
+// Replay starts delivering the messages matching the filter subject from the position chosen by the options.
+// An empty filter matches every subject of the stream.
+func (s *Stream) Replay(filter string, options *schemas.Replay, deliver ReplayFunc) (*Replay, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil, StreamClosedError
+	}
+
+	r := &Replay{
+		stream:  s,
+		filter:  filter,
+		deliver: deliver,
+		next:    s.firstSeq,
+		signal:  make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+	}
+
+	switch {
+	case options.LastPerSubject:
+		for subject, seqs := range s.subjects {
+			if r.matches(subject) {
+				r.backlog = append(r.backlog, seqs[len(seqs)-1])
+			}
+		}
+		sort.Slice(r.backlog, func(i, j int) bool { return r.backlog[i] < r.backlog[j] })
+		r.next = s.lastSeq + 1
+	case options.StartSequence > 0:
+		if options.StartSequence > r.next {
+			r.next = options.StartSequence
+		}
+	case options.StartTime != nil:
+		r.next = s.lastSeq + 1
+		for seq := s.firstSeq; seq <= s.lastSeq; seq++ {
+			if e, ok := s.index[seq]; ok && !e.timestamp.Before(*options.StartTime) {
+				r.next = seq
+				break
+			}
+		}
+	case options.LastN > 0:
+		r.next = s.lastSeq + 1
+		found := 0
+		for seq := s.lastSeq; seq >= s.firstSeq && seq > 0 && found < options.LastN; seq-- {
+			if e, ok := s.index[seq]; ok && r.matches(e.subject) {
+				r.next = seq
+				found++
+			}
+		}
+	}
+
+	s.replays[r] = struct{}{}
+	go r.run()
+	return r, nil
+}
+
+func (r *Replay) Stream() *Stream {
+	return r.stream
+}
+
+// Filter returns the subject the replay was started for
+func (r *Replay) Filter() string {
+	return r.filter
+}
+
+// Stop ends the replay. It is safe to call more than once.
+func (r *Replay) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.quit)
+	})
+}
+
+// notify wakes up the replay to deliver new messages
+func (r *Replay) notify() {
+	select {
+	case r.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Replay) matches(subject string) bool {
+	return len(r.filter) == 0 || routing.MatchSubject(subject, r.filter)
+}
+
+func (r *Replay) run() {
+	defer r.stream.removeReplay(r)
+
+	for _, seq := range r.backlog {
+		if !r.send(seq) {
+			return
+		}
+	}
+	r.backlog = nil
+
+	for {
+		seqs := r.stream.pendingReplay(r)
+		for _, seq := range seqs {
+			if !r.send(seq) {
+				return
+			}
+		}
+		if len(seqs) == replayBatch {
+			continue
+		}
+
+		select {
+		case <-r.quit:
+			return
+		case <-r.signal:
+		}
+	}
+}
+
+// send delivers the message, and tells whether the replay should go on
+func (r *Replay) send(seq uint64) bool {
+	select {
+	case <-r.quit:
+		return false
+	default:
+	}
+
+	stored, err := r.stream.Get(seq)
+	if err != nil {
+		// The message was deleted since it was selected
+		return true
+	}
+	if err := r.deliver(stored); err != nil {
+		log.Printf("Replay of stream %s failed to deliver: %v\n", r.stream.Name(), err)
+		r.Stop()
+		return false
+	}
+	return true
+}
+
+// pendingReplay returns the next batch of matching sequences the replay has not delivered yet
+func (s *Stream) pendingReplay(r *Replay) []uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	seqs := make([]uint64, 0)
+	if r.next < s.firstSeq {
+		r.next = s.firstSeq
+	}
+	for ; r.next <= s.lastSeq && len(seqs) < replayBatch; r.next++ {
+		if e, ok := s.index[r.next]; ok && r.matches(e.subject) {
+			seqs = append(seqs, r.next)
+		}
+	}
+	return seqs
+}
+
+func (s *Stream) removeReplay(r *Replay) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.replays, r)
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"testing"
+	"time"
+)
+
+func replayCollector(t *testing.T, s *Stream, filter string, options *schemas.Replay) (*Replay, chan delivery) {
+	ch := make(chan delivery, 100)
+	r, err := s.Replay(filter, options, func(stored *schemas.StoredMessage) error {
+		ch <- delivery{stored.Sequence, 1}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	return r, ch
+}
+
+func TestReplayStartPositions(t *testing.T) {
+	s, err := OpenStream(t.TempDir(), &schemas.Stream{Name: "events"})
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	for _, subject := range []string{"events.a", "events.b", "events.a", "events.c", "events.b"} {
+		if _, err := s.Append(&schemas.Publish{Subject: subject}, nil); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		filter  string
+		options *schemas.Replay
+		want    []uint64
+	}{
+		{"All", "", &schemas.Replay{}, []uint64{1, 2, 3, 4, 5}},
+		{"Filtered", "events.a", &schemas.Replay{}, []uint64{1, 3}},
+		{"Start sequence", "", &schemas.Replay{StartSequence: 4}, []uint64{4, 5}},
+		{"Last N", "events.b", &schemas.Replay{LastN: 1}, []uint64{5}},
+		{"Last N of all", "", &schemas.Replay{LastN: 2}, []uint64{4, 5}},
+		{"Last per subject", "events.*", &schemas.Replay{LastPerSubject: true}, []uint64{3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ch := replayCollector(t, s, tt.filter, tt.options)
+			defer r.Stop()
+
+			for _, seq := range tt.want {
+				if d := receive(t, ch); d.seq != seq {
+					t.Fatalf("replayed %d, want %d", d.seq, seq)
+				}
+			}
+			select {
+			case d := <-ch:
+				t.Errorf("unexpected replay of %d", d.seq)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestReplayStartTime(t *testing.T) {
+	s, err := OpenStream(t.TempDir(), &schemas.Stream{Name: "orders"})
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	appendN(t, s, 2)
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	appendN(t, s, 1)
+
+	r, ch := replayCollector(t, s, "", &schemas.Replay{StartTime: &start})
+	defer r.Stop()
+
+	if d := receive(t, ch); d.seq != 3 {
+		t.Fatalf("replayed %d, want 3", d.seq)
+	}
+}
+
+func TestReplaySwitchesToLive(t *testing.T) {
+	s, err := OpenStream(t.TempDir(), &schemas.Stream{Name: "orders"})
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	appendN(t, s, 300)
+	r, ch := replayCollector(t, s, "", &schemas.Replay{})
+	defer r.Stop()
+	appendN(t, s, 10)
+
+	for seq := uint64(1); seq <= 310; seq++ {
+		if d := receive(t, ch); d.seq != seq {
+			t.Fatalf("replayed %d, want %d", d.seq, seq)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"github.com/tfes-dev/tfes/pkg/routing"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"log"
+	"time"
+)
+
+// enforceLimits deletes the oldest messages until the stream is within its configured limits.
+// Only the given subject is checked against the per subject limit, or every subject if it is empty.
+// The stream lock must be held.
+func (s *Stream) enforceLimits(subject string) {
+	config := s.config
+
+	if config.MaxMessagesPerSubject > 0 {
+		subjects := []string{subject}
+		if len(subject) == 0 {
+			subjects = make([]string, 0, len(s.subjects))
+			for subject := range s.subjects {
+				subjects = append(subjects, subject)
+			}
+		}
+
+		for _, subject := range subjects {
+			for len(s.subjects[subject]) > config.MaxMessagesPerSubject {
+				if !s.deleteOrLog(s.subjects[subject][0]) {
+					break
+				}
+			}
+		}
+	}
+
+	for config.MaxMessages > 0 && len(s.index) > config.MaxMessages {
+		if !s.deleteOrLog(s.firstSeq) {
+			break
+		}
+	}
+
+	for config.MaxBytes > 0 && s.bytes > config.MaxBytes {
+		if !s.deleteOrLog(s.firstSeq) {
+			break
+		}
+	}
+
+	if config.MaxAge > 0 {
+		cutoff := time.Now().Add(-time.Duration(config.MaxAge) * time.Millisecond)
+		for len(s.index) > 0 && s.index[s.firstSeq].timestamp.Before(cutoff) {
+			if !s.deleteOrLog(s.firstSeq) {
+				break
+			}
+		}
+	}
+
+	// Under interest retention, a message nobody is interested in is never going to be acknowledged
+	if config.Retention == schemas.RetentionInterest && len(s.index) > 0 && s.index[s.lastSeq] != nil {
+		if len(s.interestedConsumers(s.index[s.lastSeq].subject)) == 0 {
+			s.deleteOrLog(s.lastSeq)
+		}
+	}
+}
+
+// acknowledged is called by consumers once they have acknowledged a message,
+// deleting the message if the retention policy says it is no longer needed.
+// It must be called without holding the consumer's lock.
+func (s *Stream) acknowledged(seq uint64) {
+	switch s.config.Retention {
+	case schemas.RetentionWorkQueue:
+	case schemas.RetentionInterest:
+		s.lock.RLock()
+		e, ok := s.index[seq]
+		s.lock.RUnlock()
+		if !ok {
+			return
+		}
+		for _, consumer := range s.interestedConsumers(e.subject) {
+			if !consumer.hasAcked(seq) {
+				return
+			}
+		}
+	default:
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.index[seq]; ok && !s.closed {
+		s.deleteOrLog(seq)
+	}
+}
+
+func (s *Stream) interestedConsumers(subject string) []*Consumer {
+	consumers := make([]*Consumer, 0, len(s.consumers))
+	for _, consumer := range s.consumers {
+		if len(consumer.config.FilterSubject) == 0 || routing.MatchSubject(subject, consumer.config.FilterSubject) {
+			consumers = append(consumers, consumer)
+		}
+	}
+	return consumers
+}
+
+func (s *Stream) deleteOrLog(seq uint64) bool {
+	if err := s.delete(seq); err != nil {
+		log.Printf("Failed to delete message %d from stream %s: %v\n", seq, s.config.Name, err)
+		return false
+	}
+	return true
+}
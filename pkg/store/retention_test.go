@@ -0,0 +1,178 @@
+package store
+
+import (
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionLimits(t *testing.T) {
+	dir := t.TempDir()
+	config := &schemas.Stream{Name: "orders", MaxMessages: 5, MaxSegmentBytes: 256}
+
+	s, err := OpenStream(dir, config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	appendN(t, s, 20)
+	s.Close()
+
+	// Deletions must survive a restart, and fully deleted segments must be removed
+	s, err = OpenStream(dir, config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	if first, last, count := s.State(); first != 16 || last != 20 || count != 5 {
+		t.Errorf("State() = %d, %d, %d, want 16, 20, 5", first, last, count)
+	}
+	if _, err := s.Get(15); err != MessageNotFoundError {
+		t.Errorf("Get() of a deleted message error = %v, want %v", err, MessageNotFoundError)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	if len(segments) > 3 {
+		t.Errorf("expected dead segments to be removed, %d are left", len(segments))
+	}
+}
+
+func TestRetentionPerSubject(t *testing.T) {
+	s, err := OpenStream(t.TempDir(), &schemas.Stream{Name: "config", MaxMessagesPerSubject: 1})
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	for _, subject := range []string{"config.a", "config.b", "config.a", "config.a"} {
+		if _, err := s.Append(&schemas.Publish{Subject: subject}, nil); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	if first, last, count := s.State(); first != 2 || last != 4 || count != 2 {
+		t.Errorf("State() = %d, %d, %d, want 2, 4, 2", first, last, count)
+	}
+}
+
+func TestRetentionWorkQueue(t *testing.T) {
+	s, err := OpenStream(t.TempDir(), &schemas.Stream{
+		Name:      "jobs",
+		Retention: schemas.RetentionWorkQueue,
+		Consumers: []*schemas.Consumer{{Name: "workers"}},
+	})
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	appendN(t, s, 2)
+	ch := bindCollector(s.Consumer("workers"))
+	receive(t, ch)
+	receive(t, ch)
+
+	if err := s.Consumer("workers").Ack(1); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	if _, _, count := s.State(); count != 1 {
+		t.Errorf("State() count = %d, want the acknowledged message deleted", count)
+	}
+	if _, err := s.Get(2); err != nil {
+		t.Errorf("Get() of the unacknowledged message error = %v", err)
+	}
+}
+
+func TestRetentionInterest(t *testing.T) {
+	s, err := OpenStream(t.TempDir(), &schemas.Stream{
+		Name:      "orders",
+		Retention: schemas.RetentionInterest,
+		Consumers: []*schemas.Consumer{{Name: "billing"}, {Name: "shipping"}, {Name: "audit", FilterSubject: "audit.>"}},
+	})
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	appendN(t, s, 1)
+	receive(t, bindCollector(s.Consumer("billing")))
+	receive(t, bindCollector(s.Consumer("shipping")))
+
+	s.Consumer("billing").Ack(1)
+	if _, _, count := s.State(); count != 1 {
+		t.Fatalf("message deleted before every interested consumer acknowledged it")
+	}
+	s.Consumer("shipping").Ack(1)
+	if _, _, count := s.State(); count != 0 {
+		t.Errorf("State() count = %d, want the message deleted", count)
+	}
+}
+
+func TestRetentionWorkQueueGivesUp(t *testing.T) {
+	s, err := OpenStream(t.TempDir(), &schemas.Stream{
+		Name:      "jobs",
+		Retention: schemas.RetentionWorkQueue,
+		Consumers: []*schemas.Consumer{{Name: "workers", AckWait: 50, MaxDeliveries: 1}},
+	})
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	appendN(t, s, 1)
+	receive(t, bindCollector(s.Consumer("workers")))
+
+	// The message is never acknowledged, and is deleted once the consumer gives up on it
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, _, count := s.State(); count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("message given up on is still in the stream")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRetentionWorkQueueDeadLetters(t *testing.T) {
+	s, err := OpenStream(t.TempDir(), &schemas.Stream{
+		Name:      "jobs",
+		Subjects:  []string{"orders.>"},
+		Retention: schemas.RetentionWorkQueue,
+		Consumers: []*schemas.Consumer{{Name: "workers", AckWait: 50, MaxDeliveries: 1, DeadLetterSubject: "dead.jobs"}},
+	})
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	deadLetters := make(chan *schemas.Message, 1)
+	s.OnDeadLetter(func(msg *schemas.Message) {
+		deadLetters <- msg
+	})
+
+	appendN(t, s, 1)
+	receive(t, bindCollector(s.Consumer("workers")))
+
+	select {
+	case msg := <-deadLetters:
+		if msg.Header.DeadLetter.Sequence != 1 {
+			t.Errorf("dead letter = %+v", msg.Header.DeadLetter)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the dead letter")
+	}
+
+	// Dead-lettering counts as the end of the message, which no longer waits in the queue
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, _, count := s.State(); count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dead-lettered message is still in the stream")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
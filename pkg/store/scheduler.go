@@ -0,0 +1,170 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	ScheduledSubjectPrefix = "$SCHED."
+	schedulerDir           = "_scheduled"
+)
+
+var (
+	ScheduleNotFoundError   = errors.New("scheduled message not found")
+	SchedulingDisabledError = errors.New("scheduling requires a storage directory to be configured")
+)
+
+// FireFunc publishes a scheduled message once it is due
+type FireFunc func(scheduled *schemas.Scheduled)
+
+// Scheduler holds publishes until they are due.
+// Each scheduled publish is kept on a subject of its own in a stream of the scheduler, so that it survives restarts,
+// and is removed once it has been published or cancelled.
+type Scheduler struct {
+	stream *Stream
+
+	lock   sync.Mutex
+	timers map[string]*time.Timer
+	fire   FireFunc
+	closed bool
+}
+
+func openScheduler(dir string) (*Scheduler, error) {
+	stream, err := OpenStream(filepath.Join(dir, schedulerDir), &schemas.Stream{
+		Name:     schedulerDir,
+		Subjects: []string{ScheduledSubjectPrefix + ">"},
+		Sync:     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scheduler{stream: stream, timers: make(map[string]*time.Timer)}, nil
+}
+
+// Start arms the timers of the scheduled publishes recovered from disk, and of those scheduled from now on.
+// Publishes which came due while the server was down are published right away.
+func (s *Scheduler) Start(fire FireFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.fire = fire
+
+	s.stream.lock.RLock()
+	subjects := make([]string, 0, len(s.stream.subjects))
+	for subject := range s.stream.subjects {
+		subjects = append(subjects, subject)
+	}
+	s.stream.lock.RUnlock()
+
+	for _, subject := range subjects {
+		stored, err := s.stream.LastBySubject(subject)
+		if err != nil {
+			continue
+		}
+		var scheduled schemas.Scheduled
+		if err := decodeBody(stored, &scheduled); err != nil {
+			log.Printf("Dropping unreadable scheduled message %s: %v\n", subject, err)
+			s.stream.Purge(subject, 0)
+			continue
+		}
+		s.arm(&scheduled)
+	}
+
+	if len(subjects) > 0 {
+		log.Printf("Recovered %d scheduled messages\n", len(subjects))
+	}
+}
+
+// Schedule stores the publish and assigns it an id, to cancel it with
+func (s *Scheduler) Schedule(publish *schemas.Publish, header *schemas.Header) (*schemas.Scheduled, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	deliverAt := time.Now().Add(time.Duration(publish.Delay) * time.Millisecond)
+	if publish.DeliverAt != nil {
+		deliverAt = *publish.DeliverAt
+	}
+
+	// The publish is stored as it will be made once due
+	due := *publish
+	due.DeliverAt = nil
+	due.Delay = 0
+	scheduled := &schemas.Scheduled{
+		Id:        hex.EncodeToString(id),
+		DeliverAt: deliverAt.UTC(),
+		Header:    header,
+		Publish:   &due,
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil, StreamClosedError
+	}
+	if _, err := s.stream.Append(&schemas.Publish{Subject: ScheduledSubjectPrefix + scheduled.Id, Body: scheduled}, nil); err != nil {
+		return nil, err
+	}
+	s.arm(scheduled)
+	return scheduled, nil
+}
+
+// Cancel drops the scheduled publish, unless it has already been published
+func (s *Scheduler) Cancel(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	timer, ok := s.timers[id]
+	if !ok || !timer.Stop() {
+		return ScheduleNotFoundError
+	}
+	delete(s.timers, id)
+	return s.stream.Purge(ScheduledSubjectPrefix+id, 0)
+}
+
+func (s *Scheduler) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	for id, timer := range s.timers {
+		timer.Stop()
+		delete(s.timers, id)
+	}
+	s.lock.Unlock()
+
+	return s.stream.Close()
+}
+
+// arm starts the timer publishing the message once due. The scheduler lock must be held.
+// Nothing is armed before the scheduler starts, since Start arms every message stored by then.
+func (s *Scheduler) arm(scheduled *schemas.Scheduled) {
+	if s.fire == nil {
+		return
+	}
+
+	s.timers[scheduled.Id] = time.AfterFunc(time.Until(scheduled.DeliverAt), func() {
+		s.lock.Lock()
+		if _, ok := s.timers[scheduled.Id]; !ok || s.closed {
+			s.lock.Unlock()
+			return
+		}
+		delete(s.timers, scheduled.Id)
+		fire := s.fire
+		s.lock.Unlock()
+
+		// The message is removed after it is published, so a crash in between publishes it again on restart
+		fire(scheduled)
+		if err := s.stream.Purge(ScheduledSubjectPrefix+scheduled.Id, 0); err != nil {
+			log.Printf("Failed to remove scheduled message %s: %v\n", scheduled.Id, err)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"testing"
+	"time"
+)
+
+func startScheduler(t *testing.T, dir string) (*Scheduler, chan *schemas.Scheduled) {
+	s, err := openScheduler(dir)
+	if err != nil {
+		t.Fatalf("openScheduler() error = %v", err)
+	}
+	fired := make(chan *schemas.Scheduled, 10)
+	s.Start(func(scheduled *schemas.Scheduled) {
+		fired <- scheduled
+	})
+	return s, fired
+}
+
+func TestSchedulerFiresAndCancels(t *testing.T) {
+	s, fired := startScheduler(t, t.TempDir())
+	defer s.Close()
+
+	kept, err := s.Schedule(&schemas.Publish{Subject: "reminders", Body: "kept", Delay: 50}, nil)
+	if err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	cancelled, err := s.Schedule(&schemas.Publish{Subject: "reminders", Body: "cancelled", Delay: 50}, nil)
+	if err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	if err := s.Cancel(cancelled.Id); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+
+	select {
+	case scheduled := <-fired:
+		if scheduled.Id != kept.Id || scheduled.Publish.Delay != 0 {
+			t.Errorf("fired %+v", scheduled)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the scheduled message")
+	}
+
+	select {
+	case scheduled := <-fired:
+		t.Errorf("cancelled message fired: %+v", scheduled)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := s.Cancel(kept.Id); err != ScheduleNotFoundError {
+		t.Errorf("Cancel() of a fired message error = %v, want %v", err, ScheduleNotFoundError)
+	}
+}
+
+func TestSchedulerSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := startScheduler(t, dir)
+
+	at := time.Now().Add(100 * time.Millisecond)
+	scheduled, err := s.Schedule(&schemas.Publish{Subject: "reminders", DeliverAt: &at}, nil)
+	if err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	s.Close()
+
+	s, fired := startScheduler(t, dir)
+	defer s.Close()
+
+	select {
+	case recovered := <-fired:
+		if recovered.Id != scheduled.Id {
+			t.Errorf("fired %s, want %s", recovered.Id, scheduled.Id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the recovered message")
+	}
+}
+
+func TestSchedulerArmsOnStart(t *testing.T) {
+	s, err := openScheduler(t.TempDir())
+	if err != nil {
+		t.Fatalf("openScheduler() error = %v", err)
+	}
+	defer s.Close()
+
+	scheduled, err := s.Schedule(&schemas.Publish{Subject: "reminders", Delay: 10}, nil)
+	if err != nil {
+		t.Fatalf("Schedule() before Start() error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// The message came due before the scheduler started, so it fires right away
+	fired := make(chan *schemas.Scheduled, 1)
+	s.Start(func(scheduled *schemas.Scheduled) {
+		fired <- scheduled
+	})
+
+	select {
+	case got := <-fired:
+		if got.Id != scheduled.Id {
+			t.Errorf("fired %s, want %s", got.Id, scheduled.Id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the scheduled message")
+	}
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tfes-dev/tfes/pkg/routing"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	NoStreamError        = errors.New("no stream stores the subject")
+	AmbiguousStreamError = errors.New("more than one stream stores the subject, so the expected last sequence is ambiguous")
+	InvalidStreamError   = errors.New("invalid stream name")
+	DuplicateStreamError = errors.New("stream name is already used")
+	ReservedStreamError  = errors.New("stream name is reserved")
+)
+
+// reservedStreamPrefixes start the names of the streams kept by the store itself, which configured streams may not use
+var reservedStreamPrefixes = []string{KvStreamPrefix, ObjectStreamPrefix, schedulerDir}
+
+// Store holds the persistent streams, key-value buckets and object stores, and captures the publishes matching their subjects
+type Store struct {
+	streams  map[string]*Stream
+	buckets  map[string]*Bucket
+	objects  map[string]*ObjectStore
+	subjects *routing.Sublist
+
+	// scheduler is nil unless a storage directory is configured
+	scheduler *Scheduler
+}
+
+// NewStore opens every configured stream, bucket and object store, along with the scheduler if a directory is set. A nil config results in a store without streams.
+func NewStore(config *schemas.Storage) (*Store, error) {
+	s := &Store{
+		streams:  make(map[string]*Stream),
+		buckets:  make(map[string]*Bucket),
+		objects:  make(map[string]*ObjectStore),
+		subjects: routing.NewSublist(),
+	}
+	if config == nil {
+		return s, nil
+	}
+
+	for _, streamConfig := range config.Streams {
+		if err := checkReserved(streamConfig.Name); err != nil {
+			s.Close()
+			return nil, err
+		}
+		if _, err := s.openStream(config.Dir, streamConfig); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	for _, bucketConfig := range config.Buckets {
+		stream, err := s.openStream(config.Dir, bucketStream(bucketConfig))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.buckets[bucketConfig.Name] = &Bucket{name: bucketConfig.Name, stream: stream}
+	}
+
+	// Without a directory the scheduler is left out, rather than writing to wherever the server was started from
+	if len(config.Dir) > 0 {
+		scheduler, err := openScheduler(config.Dir)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.scheduler = scheduler
+	}
+
+	for _, objectsConfig := range config.Objects {
+		if objectsConfig.MaxBytes > 0 {
+			s.Close()
+			return nil, ObjectMaxBytesError
+		}
+		stream, err := s.openStream(config.Dir, objectStream(objectsConfig))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		objects := newObjectStore(objectsConfig, stream)
+		if err := objects.removeOrphans(); err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.objects[objectsConfig.Name] = objects
+	}
+	return s, nil
+}
+
+func (s *Store) openStream(dir string, config *schemas.Stream) (*Stream, error) {
+	if err := s.checkStreamName(config.Name); err != nil {
+		return nil, err
+	}
+
+	stream, err := OpenStream(filepath.Join(dir, config.Name), config)
+	if err != nil {
+		return nil, err
+	}
+
+	first, last, count := stream.State()
+	log.Printf("Opened stream %s with %d messages from %d to %d\n", config.Name, count, first, last)
+
+	s.streams[config.Name] = stream
+	for _, subject := range config.Subjects {
+		s.subjects.Insert(subject, stream)
+	}
+	return stream, nil
+}
+
+// checkStreamName refuses the names which would not give the stream a directory of its own under the storage directory
+func (s *Store) checkStreamName(name string) error {
+	if len(name) == 0 || name == "." || strings.Contains(name, "..") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w: %q", InvalidStreamError, name)
+	}
+	if _, ok := s.streams[name]; ok {
+		return fmt.Errorf("%w: %s", DuplicateStreamError, name)
+	}
+	return nil
+}
+
+// checkReserved refuses the name of a configured stream which would share the directory of a stream kept by the store
+func checkReserved(name string) error {
+	for _, prefix := range reservedStreamPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return fmt.Errorf("%w: %s starts with %s", ReservedStreamError, name, prefix)
+		}
+	}
+	return nil
+}
+
+func (s *Store) Stream(name string) *Stream {
+	return s.streams[name]
+}
+
+func (s *Store) Bucket(name string) *Bucket {
+	return s.buckets[name]
+}
+
+func (s *Store) Objects(name string) *ObjectStore {
+	return s.objects[name]
+}
+
+// OnDeadLetter sets the function publishing the messages routed to dead-letter subjects by the consumers of every stream
+func (s *Store) OnDeadLetter(fn DeadLetterFunc) {
+	for _, stream := range s.streams {
+		stream.OnDeadLetter(fn)
+	}
+}
+
+func (s *Store) Scheduler() *Scheduler {
+	return s.scheduler
+}
+
+// Capture appends the publish to every stream interested in its subject.
+// It returns DuplicateMessageError only if every one of them had already stored it, since the message is new to the others.
+func (s *Store) Capture(msg *schemas.Message) ([]*schemas.StoredMessage, error) {
+	streams := s.subjects.Match(msg.Publish.Subject)
+
+	var expected *uint64
+	if value := msg.Publish.Headers.Get(schemas.HeaderExpectedLastSequence); len(value) > 0 {
+		seq, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if len(streams) == 0 {
+			return nil, NoStreamError
+		}
+		// Streams number their messages independently, and checking them all before appending to any would race with other publishes
+		if len(streams) > 1 {
+			return nil, AmbiguousStreamError
+		}
+		expected = &seq
+	}
+
+	stored := make([]*schemas.StoredMessage, 0)
+	duplicate := false
+	for _, sub := range streams {
+		m, err := sub.(*Stream).AppendExpected(msg.Publish, msg.Header, expected)
+		if err == DuplicateMessageError {
+			duplicate = true
+			continue
+		}
+		if err != nil {
+			return stored, err
+		}
+		stored = append(stored, m)
+	}
+
+	if duplicate && len(stored) == 0 {
+		return stored, DuplicateMessageError
+	}
+	return stored, nil
+}
+
+func (s *Store) Close() error {
+	var err error
+	if s.scheduler != nil {
+		err = s.scheduler.Close()
+	}
+	for _, stream := range s.streams {
+		if closeErr := stream.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"testing"
+)
+
+// openStore opens a store in a temporary directory, which is closed when the test ends
+func openStore(t *testing.T, config *schemas.Storage) *Store {
+	config.Dir = t.TempDir()
+	s, err := NewStore(config)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func capture(s *Store, subject string, headers schemas.Headers) error {
+	_, err := s.Capture(&schemas.Message{
+		Kind:    schemas.KindPublish,
+		Publish: &schemas.Publish{Subject: subject, Headers: headers},
+	})
+	return err
+}
+
+func TestCaptureKeepsHeaders(t *testing.T) {
+	dir := t.TempDir()
+	config := &schemas.Storage{Dir: dir, Streams: []*schemas.Stream{{Name: "orders", Subjects: []string{"orders.>"}}}}
+
+	s, err := NewStore(config)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	headers := schemas.Headers{"Region": {"eu", "us"}, schemas.HeaderContentType: {"application/json"}}
+	if err := capture(s, "orders.created", headers); err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	s.Close()
+
+	s, err = NewStore(config)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	defer s.Close()
+
+	stored, err := s.Stream("orders").Get(1)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got := stored.Headers["Region"]; len(got) != 2 || got[1] != "us" {
+		t.Errorf("Headers[Region] = %v", got)
+	}
+	if got := stored.ToBounty("orders", "", 0).Headers.Get("content-type"); got != "application/json" {
+		t.Errorf("bounty content type = %q", got)
+	}
+}
+
+func TestCaptureExpectedLastSequence(t *testing.T) {
+	s := openStore(t, &schemas.Storage{Streams: []*schemas.Stream{{Name: "orders", Subjects: []string{"orders.>"}}}})
+
+	expect := func(seq string) schemas.Headers {
+		return schemas.Headers{schemas.HeaderExpectedLastSequence: {seq}}
+	}
+
+	if err := capture(s, "orders.1", expect("0")); err != nil {
+		t.Fatalf("Capture() of a new subject error = %v", err)
+	}
+	if err := capture(s, "orders.2", nil); err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	if err := capture(s, "orders.1", expect("0")); err != WrongLastSequenceError {
+		t.Errorf("Capture() with a stale sequence error = %v, want %v", err, WrongLastSequenceError)
+	}
+	if err := capture(s, "orders.1", expect("1")); err != nil {
+		t.Errorf("Capture() with the last sequence error = %v", err)
+	}
+	if err := capture(s, "other", expect("0")); err != NoStreamError {
+		t.Errorf("Capture() of an uncaptured subject error = %v, want %v", err, NoStreamError)
+	}
+}
+
+func TestCaptureExpectedLastSequenceOfSharedSubject(t *testing.T) {
+	s := openStore(t, &schemas.Storage{Streams: []*schemas.Stream{
+		{Name: "orders", Subjects: []string{"orders.>"}},
+		{Name: "audit", Subjects: []string{">"}},
+	}})
+
+	headers := schemas.Headers{schemas.HeaderExpectedLastSequence: {"0"}}
+	if err := capture(s, "orders.1", headers); err != AmbiguousStreamError {
+		t.Fatalf("Capture() error = %v, want %v", err, AmbiguousStreamError)
+	}
+	if _, _, count := s.Stream("audit").State(); count != 0 {
+		t.Errorf("audit stream stored %d messages, want none", count)
+	}
+}
+
+func TestCaptureDuplicateInOneStream(t *testing.T) {
+	s := openStore(t, &schemas.Storage{Streams: []*schemas.Stream{
+		{Name: "orders", Subjects: []string{"orders.>"}, DuplicateWindow: 60000},
+		{Name: "audit", Subjects: []string{">"}, DuplicateWindow: 60000},
+	}})
+
+	publish := func(subject string) ([]*schemas.StoredMessage, error) {
+		return s.Capture(&schemas.Message{
+			Kind:    schemas.KindPublish,
+			Header:  &schemas.Header{MessageId: "m1"},
+			Publish: &schemas.Publish{Subject: subject},
+		})
+	}
+
+	if _, err := publish("audit.login"); err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	// The message id is only known to the audit stream
+	stored, err := publish("orders.created")
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("Capture() of a message new to one stream = %d stored, error %v", len(stored), err)
+	}
+	if _, err := publish("orders.created"); err != DuplicateMessageError {
+		t.Errorf("Capture() of a message known to every stream error = %v, want %v", err, DuplicateMessageError)
+	}
+}
+
+func TestNewStoreRejectsStreamNames(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  error
+	}{
+		{[]string{""}, InvalidStreamError},
+		{[]string{"../orders"}, InvalidStreamError},
+		{[]string{"orders/eu"}, InvalidStreamError},
+		{[]string{".."}, InvalidStreamError},
+		{[]string{"orders", "orders"}, DuplicateStreamError},
+	}
+	for _, tt := range tests {
+		var streams []*schemas.Stream
+		for _, name := range tt.names {
+			streams = append(streams, &schemas.Stream{Name: name, Subjects: []string{name + ".>"}})
+		}
+		if _, err := NewStore(&schemas.Storage{Dir: t.TempDir(), Streams: streams}); !errors.Is(err, tt.want) {
+			t.Errorf("NewStore() of streams %q error = %v, want %v", tt.names, err, tt.want)
+		}
+	}
+}
+
+func TestNewStoreRejectsReservedStreamNames(t *testing.T) {
+	for _, name := range []string{"KV_flags", "OBJ_models", "_scheduled"} {
+		streams := []*schemas.Stream{{Name: name, Subjects: []string{"orders.>"}}}
+		if _, err := NewStore(&schemas.Storage{Dir: t.TempDir(), Streams: streams}); !errors.Is(err, ReservedStreamError) {
+			t.Errorf("NewStore() of stream %s error = %v, want %v", name, err, ReservedStreamError)
+		}
+	}
+}
@@ -0,0 +1,615 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"github.com/tfes-dev/tfes/pkg/utils"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxSegmentBytes = 8 << 20
+	segmentExtension       = ".log"
+	expiryInterval         = time.Second
+)
+
+var (
+	MessageNotFoundError   = errors.New("message not found")
+	StreamClosedError      = errors.New("stream is closed")
+	DuplicateMessageError  = errors.New("duplicate message")
+	WrongLastSequenceError = errors.New("wrong last sequence for the subject")
+)
+
+// Stream is an append-only log of messages with monotonically increasing sequence numbers.
+// The log is split into segments, each file holding one JSON encoded record per line.
+type Stream struct {
+	config *schemas.Stream
+	dir    string
+
+	lock     sync.RWMutex
+	segments []*segment
+	index    map[uint64]*entry
+	subjects map[string][]uint64 // subjects holds the sequences stored for each subject, in order
+	bytes    int64
+	firstSeq uint64 // firstSeq is the lowest sequence still stored, or lastSeq+1 if the stream is empty
+	lastSeq  uint64
+	closed   bool
+
+	consumers   map[string]*Consumer
+	replays     map[*Replay]struct{}
+	duplicates  *utils.DuplicateWindow // duplicates is nil unless a duplicate window is configured
+	deadLetters DeadLetterFunc
+	quit        chan struct{}
+}
+
+type segment struct {
+	first uint64 // first is the sequence the segment starts at, and also its file name
+	path  string
+	file  *os.File
+	size  int64
+	live  int // live is the number of messages in the segment which have not been deleted
+}
+
+// entry locates a stored message, and keeps the fields needed without reading it back
+type entry struct {
+	segment   *segment
+	offset    int64
+	length    int
+	subject   string
+	timestamp time.Time
+}
+
+// record is a line of a segment: either a stored message, or a tombstone deleting an earlier one
+type record struct {
+	*schemas.StoredMessage
+	Deleted uint64 `json:"deleted,omitempty"`
+}
+
+// OpenStream opens the stream stored in dir, recovering its state from the existing segments
+func OpenStream(dir string, config *schemas.Stream) (*Stream, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		config:    config,
+		dir:       dir,
+		index:     make(map[uint64]*entry),
+		subjects:  make(map[string][]uint64),
+		consumers: make(map[string]*Consumer),
+		replays:   make(map[*Replay]struct{}),
+		quit:      make(chan struct{}),
+	}
+	if config.DuplicateWindow > 0 {
+		s.duplicates = utils.NewDuplicateWindow(time.Duration(config.DuplicateWindow) * time.Millisecond)
+	}
+
+	if err := s.recover(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	for _, consumerConfig := range config.Consumers {
+		consumer, err := openConsumer(s, consumerConfig)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.consumers[consumerConfig.Name] = consumer
+	}
+
+	s.lock.Lock()
+	s.enforceLimits("")
+	s.removeDeadSegments()
+	s.lock.Unlock()
+
+	go s.run()
+	return s, nil
+}
+
+func (s *Stream) Name() string {
+	return s.config.Name
+}
+
+func (s *Stream) Consumer(name string) *Consumer {
+	return s.consumers[name]
+}
+
+// Append stores the publish as the next message of the stream.
+// It returns DuplicateMessageError if the header message id was stored within the duplicate window.
+func (s *Stream) Append(publish *schemas.Publish, header *schemas.Header) (*schemas.StoredMessage, error) {
+	return s.AppendExpected(publish, header, nil)
+}
+
+// AppendExpected stores the publish like Append, provided that the last sequence stored for its subject is expected.
+// An expected sequence of 0 requires the subject to have no message, and a nil one skips the check.
+func (s *Stream) AppendExpected(publish *schemas.Publish, header *schemas.Header, expected *uint64) (*schemas.StoredMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil, StreamClosedError
+	}
+
+	if expected != nil && !s.isLast(publish.Subject, *expected) {
+		return nil, WrongLastSequenceError
+	}
+
+	now := time.Now().UTC()
+	messageId := ""
+	if header != nil {
+		messageId = header.MessageId
+	}
+	if s.duplicates != nil && len(messageId) > 0 {
+		if s.duplicates.Seen(messageId, now) {
+			return nil, DuplicateMessageError
+		}
+	}
+
+	stored, err := s.append(publish, header, now)
+	if err != nil {
+		if s.duplicates != nil && len(messageId) > 0 {
+			s.duplicates.Forget(messageId)
+		}
+		return nil, err
+	}
+
+	s.enforceLimits(stored.Subject)
+	for _, consumer := range s.consumers {
+		consumer.notify()
+	}
+	for r := range s.replays {
+		r.notify()
+	}
+	return stored, nil
+}
+
+func (s *Stream) append(publish *schemas.Publish, header *schemas.Header, now time.Time) (*schemas.StoredMessage, error) {
+	stored := &schemas.StoredMessage{
+		Sequence:  s.lastSeq + 1,
+		Timestamp: now,
+		Subject:   publish.Subject,
+		ReplyTo:   publish.ReplyTo,
+		Header:    header,
+		Headers:   publish.Headers,
+		Body:      publish.Body,
+	}
+
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, '\n')
+
+	seg, err := s.activeSegment(int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.write(seg, b); err != nil {
+		return nil, err
+	}
+
+	s.addEntry(stored, &entry{
+		segment:   seg,
+		offset:    seg.size - int64(len(b)),
+		length:    len(b),
+		subject:   stored.Subject,
+		timestamp: stored.Timestamp,
+	})
+	return stored, nil
+}
+
+// Get reads back the message stored at the sequence
+func (s *Stream) Get(seq uint64) (*schemas.StoredMessage, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.get(seq)
+}
+
+// get reads back the message stored at the sequence. The stream lock must be held.
+func (s *Stream) get(seq uint64) (*schemas.StoredMessage, error) {
+	e, ok := s.index[seq]
+	if !ok {
+		return nil, MessageNotFoundError
+	}
+
+	b := make([]byte, e.length)
+	if _, err := e.segment.file.ReadAt(b, e.offset); err != nil {
+		return nil, err
+	}
+
+	var stored schemas.StoredMessage
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// LastBySubject reads back the last message stored for the subject
+func (s *Stream) LastBySubject(subject string) (*schemas.StoredMessage, error) {
+	s.lock.RLock()
+	seq := s.lastBySubject(subject)
+	s.lock.RUnlock()
+
+	if seq == 0 {
+		return nil, MessageNotFoundError
+	}
+	return s.Get(seq)
+}
+
+// BySubject reads back every message stored for the subject, in order
+func (s *Stream) BySubject(subject string) ([]*schemas.StoredMessage, error) {
+	seqs := s.sequences(subject)
+	messages := make([]*schemas.StoredMessage, 0, len(seqs))
+	for _, seq := range seqs {
+		stored, err := s.Get(seq)
+		if err == MessageNotFoundError {
+			// Deleted since the sequences were taken
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, stored)
+	}
+	return messages, nil
+}
+
+// Purge deletes the messages of the subject, except for the last keep ones
+func (s *Stream) Purge(subject string, keep int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return StreamClosedError
+	}
+	for len(s.subjects[subject]) > keep {
+		if err := s.delete(s.subjects[subject][0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sequences returns a copy of the sequences stored for the subject
+func (s *Stream) sequences(subject string) []uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]uint64(nil), s.subjects[subject]...)
+}
+
+// lastBySubject returns the last sequence stored for the subject, or 0 if there is none
+// isLast tells if the sequence is the last one stored for the subject, 0 meaning none.
+// A key-value delete marker counts as nothing stored, so that a deleted key can be created again.
+// The stream lock must be held.
+func (s *Stream) isLast(subject string, expected uint64) bool {
+	last := s.lastBySubject(subject)
+	if last == expected {
+		return true
+	}
+	if expected != 0 {
+		return false
+	}
+
+	stored, err := s.get(last)
+	return err == nil && stored.Header != nil && stored.Header.KvOperation == schemas.KvOperationDelete
+}
+
+func (s *Stream) lastBySubject(subject string) uint64 {
+	seqs := s.subjects[subject]
+	if len(seqs) == 0 {
+		return 0
+	}
+	return seqs[len(seqs)-1]
+}
+
+// Delete removes the message from the stream
+func (s *Stream) Delete(seq uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return StreamClosedError
+	}
+	if _, ok := s.index[seq]; !ok {
+		return MessageNotFoundError
+	}
+	return s.delete(seq)
+}
+
+// OnDeadLetter sets the function publishing the messages the consumers of the stream route to dead-letter subjects
+func (s *Stream) OnDeadLetter(fn DeadLetterFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.deadLetters = fn
+}
+
+func (s *Stream) deadLetter(msg *schemas.Message) {
+	s.lock.RLock()
+	fn := s.deadLetters
+	s.lock.RUnlock()
+
+	if fn == nil {
+		log.Printf("Dropping dead letter for %s, as nothing publishes them\n", msg.Publish.Subject)
+		return
+	}
+	fn(msg)
+}
+
+// State returns the first and last sequences stored, and the number of messages in between
+func (s *Stream) State() (first uint64, last uint64, count int) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.firstSeq, s.lastSeq, len(s.index)
+}
+
+func (s *Stream) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.quit)
+	for r := range s.replays {
+		r.Stop()
+	}
+	s.lock.Unlock()
+
+	// Consumers are waited for without the lock, since they read the stream until they stop
+	for _, consumer := range s.consumers {
+		consumer.stop()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var err error
+	for _, seg := range s.segments {
+		if closeErr := seg.file.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// run periodically deletes the messages which have become too old
+func (s *Stream) run() {
+	if s.config.MaxAge <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.lock.Lock()
+			if !s.closed {
+				s.enforceLimits("")
+			}
+			s.lock.Unlock()
+		}
+	}
+}
+
+func (s *Stream) write(seg *segment, b []byte) error {
+	if _, err := seg.file.WriteAt(b, seg.size); err != nil {
+		return err
+	}
+	seg.size += int64(len(b))
+
+	if s.config.Sync {
+		return seg.file.Sync()
+	}
+	return nil
+}
+
+func (s *Stream) addEntry(stored *schemas.StoredMessage, e *entry) {
+	s.index[stored.Sequence] = e
+	s.subjects[e.subject] = append(s.subjects[e.subject], stored.Sequence)
+	s.bytes += int64(e.length)
+	e.segment.live++
+
+	if len(s.index) == 1 {
+		s.firstSeq = stored.Sequence
+	}
+	if stored.Sequence > s.lastSeq {
+		s.lastSeq = stored.Sequence
+	}
+}
+
+func (s *Stream) removeEntry(seq uint64) {
+	e, ok := s.index[seq]
+	if !ok {
+		return
+	}
+
+	delete(s.index, seq)
+	s.bytes -= int64(e.length)
+	e.segment.live--
+
+	seqs := s.subjects[e.subject]
+	for i, subjectSeq := range seqs {
+		if subjectSeq == seq {
+			seqs = append(seqs[:i], seqs[i+1:]...)
+			break
+		}
+	}
+	if len(seqs) == 0 {
+		delete(s.subjects, e.subject)
+	} else {
+		s.subjects[e.subject] = seqs
+	}
+
+	if seq == s.firstSeq {
+		s.firstSeq = s.lastSeq + 1
+		for next := seq + 1; next <= s.lastSeq; next++ {
+			if _, ok := s.index[next]; ok {
+				s.firstSeq = next
+				break
+			}
+		}
+	}
+}
+
+// delete writes a tombstone for the message, so that it stays deleted after a restart
+func (s *Stream) delete(seq uint64) error {
+	b, err := json.Marshal(&record{Deleted: seq})
+	if err != nil {
+		return err
+	}
+
+	// Tombstones always go to the last segment, which therefore never rolls over without a message
+	if err := s.write(s.segments[len(s.segments)-1], append(b, '\n')); err != nil {
+		return err
+	}
+
+	s.removeEntry(seq)
+	s.removeDeadSegments()
+	return nil
+}
+
+// removeDeadSegments removes the oldest segments once none of their messages are left.
+// Segments are only removed from the head, since tombstones of their messages are in later segments.
+func (s *Stream) removeDeadSegments() {
+	for len(s.segments) > 1 && s.segments[0].live == 0 {
+		seg := s.segments[0]
+		seg.file.Close()
+		if err := os.Remove(seg.path); err != nil {
+			log.Println("Failed to remove segment:", err)
+		}
+		s.segments = s.segments[1:]
+	}
+}
+
+// activeSegment returns the segment to append to, starting a new one if the current one is full
+func (s *Stream) activeSegment(length int64) (*segment, error) {
+	maxBytes := s.config.MaxSegmentBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxSegmentBytes
+	}
+
+	if len(s.segments) > 0 {
+		seg := s.segments[len(s.segments)-1]
+		if seg.size == 0 || seg.size+length <= maxBytes {
+			return seg, nil
+		}
+	}
+
+	first := s.lastSeq + 1
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", first, segmentExtension))
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	seg := &segment{first: first, path: path, file: file}
+	s.segments = append(s.segments, seg)
+	return seg, nil
+}
+
+// recover rebuilds the index from the segments on disk.
+// A partially written record at the end of the last segment, left behind by a crash, is truncated.
+func (s *Stream) recover() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	firsts := make([]uint64, 0)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), segmentExtension) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		firsts = append(firsts, first)
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+
+	for i, first := range firsts {
+		path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", first, segmentExtension))
+		file, err := os.OpenFile(path, os.O_RDWR, 0644)
+		if err != nil {
+			return err
+		}
+
+		seg := &segment{first: first, path: path, file: file}
+		s.segments = append(s.segments, seg)
+		if err := s.recoverSegment(seg, i == len(firsts)-1); err != nil {
+			return err
+		}
+	}
+
+	// A stream whose messages were all deleted continues from the sequence of its last segment
+	if len(s.segments) > 0 && s.lastSeq < s.segments[len(s.segments)-1].first-1 {
+		s.lastSeq = s.segments[len(s.segments)-1].first - 1
+	}
+
+	s.firstSeq = s.lastSeq + 1
+	for seq := range s.index {
+		if seq < s.firstSeq {
+			s.firstSeq = seq
+		}
+	}
+	return nil
+}
+
+func (s *Stream) recoverSegment(seg *segment, last bool) error {
+	reader := bufio.NewReader(seg.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 || err != nil {
+			break
+		}
+
+		var rec record
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			if !last {
+				return fmt.Errorf("corrupt segment %s at offset %d: %w", seg.path, offset, err)
+			}
+			break
+		}
+
+		if rec.Deleted > 0 {
+			s.removeEntry(rec.Deleted)
+		} else if rec.StoredMessage != nil {
+			if s.duplicates != nil && rec.Header != nil && len(rec.Header.MessageId) > 0 {
+				s.duplicates.Seen(rec.Header.MessageId, rec.Timestamp)
+			}
+			s.addEntry(rec.StoredMessage, &entry{
+				segment:   seg,
+				offset:    offset,
+				length:    len(line),
+				subject:   rec.Subject,
+				timestamp: rec.Timestamp,
+			})
+		}
+		offset += int64(len(line))
+	}
+
+	seg.size = offset
+	return seg.file.Truncate(offset)
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func appendN(t *testing.T, s *Stream, n int) {
+	for i := 0; i < n; i++ {
+		if _, err := s.Append(&schemas.Publish{Subject: "orders.created", Body: float64(i)}, nil); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+}
+
+func TestStreamSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	config := &schemas.Stream{Name: "orders", MaxSegmentBytes: 256}
+
+	s, err := OpenStream(dir, config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	appendN(t, s, 10)
+	s.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	if len(segments) < 2 {
+		t.Fatalf("expected the log to roll over into several segments, got %d", len(segments))
+	}
+
+	s, err = OpenStream(dir, config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	if first, last, count := s.State(); first != 1 || last != 10 || count != 10 {
+		t.Fatalf("State() = %d, %d, %d, want 1, 10, 10", first, last, count)
+	}
+
+	stored, err := s.Get(7)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if stored.Sequence != 7 || stored.Body != float64(6) || stored.Subject != "orders.created" {
+		t.Errorf("Get() = %+v", stored)
+	}
+
+	appendN(t, s, 1)
+	if _, last, _ := s.State(); last != 11 {
+		t.Errorf("Append() after restart stored sequence %d, want 11", last)
+	}
+}
+
+func TestStreamTruncatesPartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	config := &schemas.Stream{Name: "orders"}
+
+	s, err := OpenStream(dir, config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	appendN(t, s, 3)
+	s.Close()
+
+	// Simulate a crash in the middle of writing the fourth message
+	f, _ := os.OpenFile(filepath.Join(dir, "00000000000000000001"+segmentExtension), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"seq":4,"subj`)
+	f.Close()
+
+	s, err = OpenStream(dir, config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	appendN(t, s, 1)
+	stored, err := s.Get(4)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if stored.Body != float64(0) {
+		t.Errorf("Get() = %+v, want the message appended after recovery", stored)
+	}
+}
+
+func TestStreamDropsDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	config := &schemas.Stream{Name: "orders", DuplicateWindow: 60000}
+	publish := &schemas.Publish{Subject: "orders.created"}
+	header := &schemas.Header{MessageId: "order-1"}
+
+	s, err := OpenStream(dir, config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	if _, err := s.Append(publish, header); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if _, err := s.Append(publish, header); err != DuplicateMessageError {
+		t.Fatalf("Append() of a duplicate error = %v, want %v", err, DuplicateMessageError)
+	}
+	s.Close()
+
+	// The window is rebuilt from the stored messages after a restart
+	s, err = OpenStream(dir, config)
+	if err != nil {
+		t.Fatalf("OpenStream() error = %v", err)
+	}
+	defer s.Close()
+
+	if _, err := s.Append(publish, header); err != DuplicateMessageError {
+		t.Errorf("Append() of a duplicate after restart error = %v, want %v", err, DuplicateMessageError)
+	}
+	if _, err := s.Append(publish, &schemas.Header{MessageId: "order-2"}); err != nil {
+		t.Errorf("Append() error = %v", err)
+	}
+}
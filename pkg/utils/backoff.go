@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes the waits between retries, doubling from Initial up to Max.
+// Each wait is jittered down by up to half, so that servers restarted together do not retry in lockstep.
+type Backoff struct {
+	Initial  time.Duration
+	Max      time.Duration
+	attempts int
+}
+
+func NewBackoff(initial time.Duration, max time.Duration) *Backoff {
+	return &Backoff{Initial: initial, Max: max}
+}
+
+// Next returns the wait before the next retry, and counts the attempt
+func (b *Backoff) Next() time.Duration {
+	wait := b.Initial
+	for i := 0; i < b.attempts && wait < b.Max; i++ {
+		wait *= 2
+	}
+	if wait > b.Max {
+		wait = b.Max
+	}
+	b.attempts++
+
+	half := int64(wait / 2)
+	if half <= 0 {
+		return wait
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+func (b *Backoff) Attempts() int {
+	return b.attempts
+}
+
+func (b *Backoff) Reset() {
+	b.attempts = 0
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDoublesUpToMax(t *testing.T) {
+	b := NewBackoff(100*time.Millisecond, time.Second)
+
+	// Each wait is jittered into [wait/2, wait)
+	for _, wait := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		wait *= time.Millisecond
+		got := b.Next()
+		if got < wait/2 || got >= wait {
+			t.Errorf("Next() after %d attempts = %v, want in [%v, %v)", b.Attempts()-1, got, wait/2, wait)
+		}
+	}
+	if b.Attempts() != 6 {
+		t.Errorf("Attempts() = %d, want 6", b.Attempts())
+	}
+}
+
+func TestBackoffReset(t *testing.T) {
+	b := NewBackoff(100*time.Millisecond, time.Second)
+	for i := 0; i < 10; i++ {
+		b.Next()
+	}
+
+	b.Reset()
+	if b.Attempts() != 0 {
+		t.Errorf("Attempts() after Reset() = %d, want 0", b.Attempts())
+	}
+	if got := b.Next(); got >= 100*time.Millisecond {
+		t.Errorf("Next() after Reset() = %v, want below the initial wait", got)
+	}
+}
+
+func TestBackoffWithoutJitter(t *testing.T) {
+	// Waits too short to halve are returned as they are
+	b := NewBackoff(1, 1)
+	if got := b.Next(); got != 1 {
+		t.Errorf("Next() = %v, want 1ns", got)
+	}
+}
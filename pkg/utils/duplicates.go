@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// DuplicateWindow remembers the ids seen within a sliding window of time
+type DuplicateWindow struct {
+	window time.Duration
+
+	lock  sync.Mutex
+	seen  map[string]time.Time
+	order []string // order holds the ids in the order they were seen, so that expired ones are pruned first
+}
+
+func NewDuplicateWindow(window time.Duration) *DuplicateWindow {
+	return &DuplicateWindow{
+		window: window,
+		seen:   make(map[string]time.Time),
+		order:  make([]string, 0),
+	}
+}
+
+// Seen tells if the id was already seen within the window before at, and remembers it otherwise
+func (d *DuplicateWindow) Seen(id string, at time.Time) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.prune(time.Now())
+	if seenAt, ok := d.seen[id]; ok && at.Sub(seenAt) < d.window {
+		return true
+	}
+
+	d.seen[id] = at
+	d.order = append(d.order, id)
+	return false
+}
+
+// Forget drops the id, for when the message it identified could not be processed after all
+func (d *DuplicateWindow) Forget(id string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delete(d.seen, id)
+}
+
+func (d *DuplicateWindow) prune(now time.Time) {
+	expired := 0
+	for _, id := range d.order {
+		seenAt, ok := d.seen[id]
+		if ok && now.Sub(seenAt) < d.window {
+			break
+		}
+		delete(d.seen, id)
+		expired++
+	}
+	d.order = d.order[expired:]
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultMaxHeaderBytes = 8 << 10
+)
+
+var (
+	HeadersTooLargeError = errors.New("headers are too large")
+	InvalidHeaderError   = errors.New("invalid header name")
+	ReservedHeaderError  = errors.New("header name is reserved")
+	InvalidSequenceError = errors.New("expected last sequence is not a number")
+)
+
+// CheckHeaders validates the headers set by a client, which must already be in canonical form
+func CheckHeaders(headers schemas.Headers, maxBytes int) error {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxHeaderBytes
+	}
+
+	size := 0
+	for key, values := range headers {
+		if len(key) == 0 || strings.IndexFunc(key, isInvalidHeaderRune) > -1 {
+			return InvalidHeaderError
+		}
+		if strings.HasPrefix(key, schemas.ReservedHeaderPrefix) && key != schemas.HeaderExpectedLastSequence {
+			return ReservedHeaderError
+		}
+
+		size += len(key)
+		for _, value := range values {
+			size += len(value)
+		}
+		if size > maxBytes {
+			return HeadersTooLargeError
+		}
+	}
+
+	if expected := headers.Get(schemas.HeaderExpectedLastSequence); len(expected) > 0 {
+		if _, err := strconv.ParseUint(expected, 10, 64); err != nil {
+			return InvalidSequenceError
+		}
+	}
+	return nil
+}
+
+// isInvalidHeaderRune tells if the rune can not be part of a header name, like in HTTP
+func isInvalidHeaderRune(r rune) bool {
+	return r <= ' ' || r >= 0x7f || r == ':'
+}
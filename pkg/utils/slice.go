@@ -7,10 +7,19 @@ func RemoveItem(slice []string, item string) []string {
 			index = i
 		}
 	}
-	if index >= -1 {
+	if index > -1 {
 		copy(slice[index:], slice[index+1:])
 		return slice[:len(slice)-1]
 	} else {
 		return slice
 	}
 }
+
+func ContainsItem(slice []string, item string) bool {
+	for _, subject := range slice {
+		if subject == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/tfes-dev/tfes/pkg/schemas"
+	"io/ioutil"
+	"net"
+)
+
+var (
+	InvalidCaError = errors.New("no certificates found in CA file")
+)
+
+func NewServerTlsConfig(config *schemas.Tls) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.Cert, config.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if len(config.Ca) > 0 {
+		pool, err := loadCertPool(config.Ca)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if config.VerifyClient {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// PeerCertificate returns the verified certificate presented by the remote side of a TLS connection, if any
+func PeerCertificate(conn net.Conn) *x509.Certificate {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil
+	}
+	return certs[0]
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, InvalidCaError
+	}
+	return pool, nil
+}
+
+// NewClientTlsConfig builds the configuration used to dial a TLS listener at addr,
+// presenting the certificate so that the remote side can verify it
+func NewClientTlsConfig(config *schemas.Tls, addr string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.Cert, config.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ServerName:   host,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if len(config.Ca) > 0 {
+		pool, err := loadCertPool(config.Ca)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
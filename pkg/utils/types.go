@@ -15,3 +15,10 @@ func ReturnSuccessAck() *schemas.Message {
 		Ack:  &schemas.Ack{Ok: true},
 	}
 }
+
+func ReturnDuplicateAck() *schemas.Message {
+	return &schemas.Message{
+		Kind: schemas.KindAck,
+		Ack:  &schemas.Ack{Ok: true, Duplicate: true},
+	}
+}